@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// batchExt is the extension of the ZIP a batch of reports is delivered in.
+const batchExt = ".batch.zip"
+
+// batchMaxFeatures and batchMaxJobs cap the features of one batch and the
+// batches running at once. They are set from the config in main.
+var batchMaxFeatures = 200
+var batchMaxJobs = 2
+
+// errBatchesBusy is the answer to a batch started while batchMaxJobs are
+// already running.
+var errBatchesBusy = errors.New("Too many batches of reports are being made. Try again once one has finished")
+
+// BatchJob is a batch of reports, one for each feature of an upload, made in
+// the background. Its progress is polled from /reportjob/{id}, and the ZIP of
+// reports is downloaded from Download once Status is done.
+type BatchJob struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"` // running, done or failed
+	Total    int        `json:"total"`
+	Done     int        `json:"done"`
+	Failed   int        `json:"failed"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Download string     `json:"download,omitempty"`
+	owner    string
+}
+
+// batchJobs are the batches started since the server started, by id. They
+// are forgotten with their ZIP by cleanup.
+var batchJobs = struct {
+	sync.Mutex
+	jobs map[string]*BatchJob
+}{jobs: map[string]*BatchJob{}}
+
+// batchPath is where the ZIP of a batch is kept for download.
+func batchPath(id string) string {
+	return "/tmp/" + id + batchExt
+}
+
+// batchReportFromUpload starts a batch of reports, one for every selected
+// feature of an uploaded shapefile or GeoPackage, titled from the titlefield
+// attribute. It answers at once with the job to poll.
+func batchReportFromUpload(w http.ResponseWriter, r *http.Request) {
+	opts, err := ReportOptionsFromForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+	features, err := uploadFeatures(r, r.FormValue("titlefield"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if len(features) > batchMaxFeatures {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "The batch has "+strconv.Itoa(len(features))+" features, more than the "+strconv.Itoa(batchMaxFeatures)+" allowed. Pick fewer with where or fid")
+		return
+	}
+	job, err := startBatch(features, opts, GetCookieParts(r))
+	if err != nil {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintln(w, err)
+		return
+	}
+	jobjson, _ := json.Marshal(job)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(jobjson)
+}
+
+// startBatch registers a job for the features and makes their reports for
+// user in the background, unless batchMaxJobs batches are already running.
+// The reports outlive the request, so nothing of it is kept.
+func startBatch(features []uploadFeature, opts ReportOptions, user UserClaims) (BatchJob, error) {
+	id, _ := randomFilename()
+	job := &BatchJob{
+		ID:      id,
+		Status:  "running",
+		Total:   len(features),
+		Started: time.Now(),
+		owner:   user.EMail,
+	}
+	batchJobs.Lock()
+	running := 0
+	for _, other := range batchJobs.jobs {
+		if other.Status == "running" {
+			running++
+		}
+	}
+	if running >= batchMaxJobs {
+		batchJobs.Unlock()
+		return BatchJob{}, errBatchesBusy
+	}
+	batchJobs.jobs[id] = job
+	snapshot := *job
+	batchJobs.Unlock()
+	go runBatch(context.Background(), job, features, opts, user)
+	return snapshot, nil
+}
+
+// batchEntry is one report of a batch, for the index.
+type batchEntry struct {
+	File    string
+	Feature uploadFeature
+	Report  string // path of the generated report, empty when it failed
+	Err     error
+}
+
+// runBatch makes the report for each feature in turn, then packs them into
+// the batch ZIP. The features are not added to the user's history.
+func runBatch(ctx context.Context, job *BatchJob, features []uploadFeature, opts ReportOptions, user UserClaims) {
+	writer := reportWriters[opts.Format]
+	entries := make([]batchEntry, len(features))
+	for i, feature := range features {
+		entries[i] = batchEntry{
+			File:    fmt.Sprintf("%03d %s%s", i+1, exportName(feature.Geom.Title, 80), writer.Ext),
+			Feature: feature,
+			Err:     feature.Err,
+		}
+		if entries[i].Err == nil {
+			feature.Geom.History = true
+			var fname string
+			fname, entries[i].Err = reportGen(ctx, user, feature.Geom, opts)
+			if entries[i].Err == nil {
+				entries[i].Report = "/tmp/" + fname + writer.Ext
+			}
+		}
+		batchJobs.Lock()
+		if entries[i].Err != nil {
+			log.Println("Batch "+job.ID+", FID "+strconv.FormatInt(feature.FID, 10)+": ", entries[i].Err)
+			job.Failed++
+		} else {
+			job.Done++
+		}
+		batchJobs.Unlock()
+	}
+	err := writeBatchZip(batchPath(job.ID), entries, opts.areaUnit())
+	for _, entry := range entries {
+		if entry.Report != "" {
+			os.Remove(entry.Report)
+		}
+	}
+	finished := time.Now()
+	batchJobs.Lock()
+	defer batchJobs.Unlock()
+	job.Finished = &finished
+	if err != nil {
+		log.Println("Batch "+job.ID+": ", err)
+		job.Status = "failed"
+		job.Error = err.Error()
+		return
+	}
+	job.Status = "done"
+	job.Download = "/reportjob/" + job.ID + "/download"
+}
+
+// writeBatchZip writes the reports of a batch to a ZIP with index.csv, which
+// lists every feature, the report made for it and any error.
+func writeBatchZip(path string, entries []batchEntry, units areaUnit) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	archive := zip.NewWriter(out)
+	f, err := archive.Create("index.csv")
+	if err != nil {
+		return err
+	}
+	index := csv.NewWriter(f)
+	index.Write([]string{"File", "FID", "Title", "Area (" + units.Label + ")", "Status", "Error"})
+	for _, entry := range entries {
+		file, area, status, message := entry.File, "", "ok", ""
+		if entry.Err != nil {
+			file, status, message = "", "failed", entry.Err.Error()
+		}
+		if len(entry.Feature.Geom.Rings) > 0 {
+			area = formatNumber(geodesicArea(entry.Feature.Geom.Rings)/units.Metres, units.Decimals)
+		}
+		index.Write([]string{file, strconv.FormatInt(entry.Feature.FID, 10), entry.Feature.Geom.Title, area, status, message})
+	}
+	index.Flush()
+	if err := index.Error(); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Report == "" {
+			continue
+		}
+		if err := addFileToZip(archive, entry.File, entry.Report); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// addFileToZip copies the file at path into archive as name.
+func addFileToZip(archive *zip.Writer, name string, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, in)
+	return err
+}
+
+// findBatchJob gives the progress of the batch id, if it was started by owner.
+func findBatchJob(id string, owner string) (BatchJob, bool) {
+	batchJobs.Lock()
+	defer batchJobs.Unlock()
+	job, ok := batchJobs.jobs[id]
+	if !ok || job.owner != owner {
+		return BatchJob{}, false
+	}
+	return *job, true
+}
+
+// forgetBatchJobs forgets the batches that finished more than cutoff ago.
+func forgetBatchJobs(cutoff time.Duration) {
+	batchJobs.Lock()
+	defer batchJobs.Unlock()
+	for id, job := range batchJobs.jobs {
+		if job.Finished != nil && time.Since(*job.Finished) > cutoff {
+			delete(batchJobs.jobs, id)
+		}
+	}
+}
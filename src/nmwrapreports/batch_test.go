@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestStartBatchRefusedWhileBusy(t *testing.T) {
+	batchJobs.Lock()
+	for i := 0; i < batchMaxJobs; i++ {
+		batchJobs.jobs["busy"+string(rune('a'+i))] = &BatchJob{Status: "running"}
+	}
+	batchJobs.Unlock()
+	defer func() {
+		batchJobs.Lock()
+		batchJobs.jobs = map[string]*BatchJob{}
+		batchJobs.Unlock()
+	}()
+	if _, err := startBatch(nil, ReportOptions{}, UserClaims{EMail: "someone@example.com"}); err != errBatchesBusy {
+		t.Errorf("startBatch error %v, want %v", err, errBatchesBusy)
+	}
+}
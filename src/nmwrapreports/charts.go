@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/wcharczuk/go-chart"
+	"github.com/wcharczuk/go-chart/drawing"
+)
+
+// barChart renders one bar per value, in the theme's header colour.
+func barChart(title string, values []chart.Value) ([]byte, error) {
+	color := drawing.Color{R: 199, G: 125, B: 26, A: 255}
+	if r, g, b, ok := parseHexColor(reportTheme.HeaderColor); ok {
+		color = drawing.Color{R: uint8(r), G: uint8(g), B: uint8(b), A: 255}
+	}
+	//Narrow the bars when there are too many to fit at the usual width.
+	barWidth := 60
+	if len(values) > 0 && 900/len(values)-20 < barWidth {
+		barWidth = 900/len(values) - 20
+		if barWidth < 8 {
+			barWidth = 8
+		}
+	}
+	most := 0.0
+	for i := range values {
+		values[i].Style = chart.Style{FillColor: color, StrokeColor: color}
+		if values[i].Value > most {
+			most = values[i].Value
+		}
+	}
+	bars := chart.BarChart{
+		Title:      title,
+		TitleStyle: chart.Style{Show: true},
+		Font:       reportTheme.chartFont(),
+		Width:      1024,
+		Height:     512,
+		BarWidth:   barWidth,
+		XAxis:      chart.Style{Show: true},
+		YAxis: chart.YAxis{
+			Style:          chart.Style{Show: true},
+			ValueFormatter: func(v interface{}) string { return formatNumber(v.(float64), 0) },
+		},
+		Bars: values,
+	}
+	if most == 0 {
+		//go-chart needs a non-empty range to draw the axis.
+		bars.YAxis.Range = &chart.ContinuousRange{Min: 0, Max: 1}
+	}
+	buffer := bytes.NewBuffer([]byte{})
+	if err := bars.Render(chart.PNG, buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// chartLabel shortens a name to fit under a bar.
+func chartLabel(name string) string {
+	if len([]rune(name)) > 18 {
+		return string([]rune(name)[:17]) + "…"
+	}
+	return name
+}
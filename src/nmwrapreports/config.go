@@ -18,6 +18,19 @@ type Config struct {
 	DBPass         string
 	DBName         string
 	Secret         string
+	LayerService   string
+	DiscoverLayers bool
+	Layers         []CatalogLayer
+	QueryWorkers   int
+	LayerTimeout   int
+	MaxFeatures    int
+	MapFeatures    bool
+	Theme          Theme
+	NarrativeDir   string
+	RiskScore      RiskWeights
+	Stations       StationSearch
+	BatchFeatures  int
+	BatchJobs      int
 }
 
 // ReadConfig reads info from config file
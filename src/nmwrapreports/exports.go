@@ -0,0 +1,124 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+)
+
+// SectionRecords is every attribute of every feature a section decoded,
+// headed by the ArcGIS field aliases.
+type SectionRecords struct {
+	Name    string
+	Headers []string
+	Rows    [][]string
+}
+
+// aliasedRecords builds SectionRecords from one of the ArcGIS response structs
+// (FireStations, County, ...). The columns are the fields of its FieldAliases
+// struct, in order, and each is matched to the Attributes field of the same name.
+func aliasedRecords(name string, data interface{}) SectionRecords {
+	records := SectionRecords{Name: name}
+	v := reflect.ValueOf(data)
+	aliases := v.FieldByName("FieldAliases")
+	features := v.FieldByName("Features")
+	for i := 0; i < aliases.NumField(); i++ {
+		alias := aliases.Field(i).String()
+		if alias == "" {
+			alias = strings.Split(aliases.Type().Field(i).Tag.Get("json"), ",")[0]
+		}
+		records.Headers = append(records.Headers, alias)
+	}
+	for f := 0; f < features.Len(); f++ {
+		attributes := features.Index(f).FieldByName("Attributes")
+		row := make([]string, aliases.NumField())
+		for i := range row {
+			if field := attributes.FieldByName(aliases.Type().Field(i).Name); field.IsValid() {
+				row[i] = attributeString(field.Interface())
+			}
+		}
+		records.Rows = append(records.Rows, row)
+	}
+	return records
+}
+
+// exportName makes a section title safe to use as a file or sheet name of at
+// most max characters.
+func exportName(title string, max int) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\?*:[]"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, title)
+	if runes := []rune(name); len(runes) > max {
+		name = string(runes[:max])
+	}
+	return name
+}
+
+// exportNames hands out the file or sheet names within one export, adding
+// " (2)", " (3)" and so on when a title cuts down to a name already taken.
+// Excel ignores case in sheet names, so names differing only in case are
+// taken to be the same.
+type exportNames map[string]bool
+
+func (taken exportNames) name(title string, max int) string {
+	name := exportName(title, max)
+	for n := 2; taken[strings.ToLower(name)]; n++ {
+		suffix := " (" + strconv.Itoa(n) + ")"
+		name = exportName(title, max-len(suffix)) + suffix
+	}
+	taken[strings.ToLower(name)] = true
+	return name
+}
+
+// writeCSVExport writes a ZIP holding one CSV file per section.
+func writeCSVExport(report Report, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	archive := zip.NewWriter(out)
+	names := exportNames{}
+	for _, records := range report.Records {
+		f, err := archive.Create(names.name(records.Name, 100) + ".csv")
+		if err != nil {
+			return err
+		}
+		w := csv.NewWriter(f)
+		w.Write(records.Headers)
+		w.WriteAll(records.Rows)
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// writeXLSXExport writes a workbook with one sheet per section.
+func writeXLSXExport(report Report, path string) error {
+	xlsx := excelize.NewFile()
+	names := exportNames{}
+	for i, records := range report.Records {
+		sheet := names.name(records.Name, 31)
+		if i == 0 {
+			xlsx.SetSheetName("Sheet1", sheet)
+		} else {
+			xlsx.NewSheet(sheet)
+		}
+		headers := records.Headers
+		xlsx.SetSheetRow(sheet, "A1", &headers)
+		for r := range records.Rows {
+			row := records.Rows[r]
+			xlsx.SetSheetRow(sheet, "A"+strconv.Itoa(r+2), &row)
+		}
+	}
+	return xlsx.SaveAs(path)
+}
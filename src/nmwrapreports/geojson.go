@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+)
+
+// GeoJSONFeatureCollection is a GeoJSON FeatureCollection.
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// GeoJSONFeature is a GeoJSON Feature.
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   *GeoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GeoJSONGeometry is any GeoJSON geometry. Coordinates are nested []float64
+// slices as deep as the geometry type needs.
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// LayerFeatures is the raw query response of a section, kept for the exports
+// that need more than the decoded attributes.
+type LayerFeatures struct {
+	Name string
+	Body []byte
+}
+
+// esriFeatureSet is an ArcGIS query response with geometry.
+type esriFeatureSet struct {
+	Features []struct {
+		Attributes map[string]interface{} `json:"attributes"`
+		Geometry   *EsriGeometry          `json:"geometry"`
+	} `json:"features"`
+}
+
+// toGeoJSON converts a Web Mercator Esri geometry into a GeoJSON geometry in
+// EPSG:4326. Rings are reversed to follow the GeoJSON right hand rule.
+func (g *EsriGeometry) toGeoJSON() *GeoJSONGeometry {
+	switch {
+	case g == nil:
+		return nil
+	case g.X != nil && g.Y != nil:
+		lon, lat := mercatorToLonLat(*g.X, *g.Y)
+		return &GeoJSONGeometry{Type: "Point", Coordinates: []float64{lon, lat}}
+	case len(g.Points) > 0:
+		return &GeoJSONGeometry{Type: "MultiPoint", Coordinates: ringsToLonLat([][][]float64{g.Points})[0]}
+	case len(g.Paths) > 0:
+		return &GeoJSONGeometry{Type: "MultiLineString", Coordinates: ringsToLonLat(g.Paths)}
+	case len(g.Rings) > 0:
+		var polygons [][][][]float64
+		for _, polygon := range polygonsFromRings(g.Rings) {
+			for i := range polygon {
+				polygon[i] = reverseRing(polygon[i])
+			}
+			polygons = append(polygons, ringsToLonLat(polygon))
+		}
+		if len(polygons) == 1 {
+			return &GeoJSONGeometry{Type: "Polygon", Coordinates: polygons[0]}
+		}
+		return &GeoJSONGeometry{Type: "MultiPolygon", Coordinates: polygons}
+	}
+	return nil
+}
+
+// featureCollection turns a raw ArcGIS query response into a GeoJSON FeatureCollection.
+func featureCollection(body []byte) (GeoJSONFeatureCollection, error) {
+	collection := GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+	var features esriFeatureSet
+	if err := json.Unmarshal(body, &features); err != nil {
+		return collection, err
+	}
+	for _, feature := range features.Features {
+		collection.Features = append(collection.Features, GeoJSONFeature{
+			Type:       "Feature",
+			Geometry:   feature.Geometry.toGeoJSON(),
+			Properties: feature.Attributes,
+		})
+	}
+	return collection, nil
+}
+
+// aoiFeatureCollection is the user's area of interest as GeoJSON.
+func aoiFeatureCollection(aoi Geom) GeoJSONFeatureCollection {
+	geometry := &EsriGeometry{Rings: aoi.Rings}
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{{
+		Type:       "Feature",
+		Geometry:   geometry.toGeoJSON(),
+		Properties: map[string]interface{}{"title": aoi.Title},
+	}}}
+}
+
+// writeGeoJSONExport writes a ZIP with the area of interest and one GeoJSON
+// FeatureCollection per section, all in EPSG:4326.
+func writeGeoJSONExport(report Report, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	archive := zip.NewWriter(out)
+	if err := writeZipJSON(archive, "Area of interest.geojson", aoiFeatureCollection(report.AOI)); err != nil {
+		return err
+	}
+	names := exportNames{"area of interest": true}
+	for _, layer := range report.Layers {
+		collection, err := featureCollection(layer.Body)
+		if err != nil {
+			return err
+		}
+		if err := writeZipJSON(archive, names.name(layer.Name, 100)+".geojson", collection); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// writeZipJSON adds v to archive as a JSON file called name.
+func writeZipJSON(archive *zip.Writer, name string, v interface{}) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(f).Encode(v)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+)
+
+// earthRadius is the sphere radius used by Web Mercator (EPSG:3857), in metres.
+const earthRadius = 6378137.0
+
+// EsriGeometry is the geometry of a feature in an ArcGIS query response.
+// Only the members for the layer's geometry type are set.
+type EsriGeometry struct {
+	X      *float64      `json:"x"`
+	Y      *float64      `json:"y"`
+	Points [][]float64   `json:"points"`
+	Paths  [][][]float64 `json:"paths"`
+	Rings  [][][]float64 `json:"rings"`
+}
+
+// mercatorToLonLat converts a Web Mercator x/y in metres to longitude/latitude in degrees.
+func mercatorToLonLat(x, y float64) (lon, lat float64) {
+	lon = x / earthRadius * 180 / math.Pi
+	lat = (2*math.Atan(math.Exp(y/earthRadius)) - math.Pi/2) * 180 / math.Pi
+	return lon, lat
+}
+
+// lonLatToMercator converts longitude/latitude in degrees to Web Mercator x/y in metres.
+func lonLatToMercator(lon, lat float64) (x, y float64) {
+	x = lon * math.Pi / 180 * earthRadius
+	y = math.Log(math.Tan(math.Pi/4+lat*math.Pi/360)) * earthRadius
+	return x, y
+}
+
+// ringsToLonLat converts Web Mercator rings or paths to longitude/latitude.
+func ringsToLonLat(rings [][][]float64) [][][]float64 {
+	out := make([][][]float64, len(rings))
+	for i, ring := range rings {
+		out[i] = make([][]float64, len(ring))
+		for j, pt := range ring {
+			lon, lat := mercatorToLonLat(pt[0], pt[1])
+			out[i][j] = []float64{lon, lat}
+		}
+	}
+	return out
+}
+
+// ringArea is the signed planar area of a ring. Esri outer rings run
+// clockwise and so have a negative area; holes are positive.
+func ringArea(ring [][]float64) float64 {
+	area := 0.0
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		area += ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+	}
+	return area / 2
+}
+
+// reverseRing returns ring with its vertices in the opposite order.
+func reverseRing(ring [][]float64) [][]float64 {
+	out := make([][]float64, len(ring))
+	for i, pt := range ring {
+		out[len(ring)-1-i] = pt
+	}
+	return out
+}
+
+// pointInRing uses the even-odd rule to say whether x, y falls inside ring.
+func pointInRing(x, y float64, ring [][]float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// polygonsFromRings groups Esri rings into polygons: each clockwise outer ring
+// followed by the counter-clockwise holes that fall inside it.
+func polygonsFromRings(rings [][][]float64) [][][][]float64 {
+	var polygons [][][][]float64
+	var holes [][][]float64
+	for _, ring := range rings {
+		if len(ring) < 3 {
+			continue
+		}
+		if ringArea(ring) <= 0 {
+			polygons = append(polygons, [][][]float64{ring})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	for _, hole := range holes {
+		placed := false
+		for i, polygon := range polygons {
+			if pointInRing(hole[0][0], hole[0][1], polygon[0]) {
+				polygons[i] = append(polygons[i], hole)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			//A lone counter-clockwise ring is an outer ring drawn the wrong way round.
+			polygons = append(polygons, [][][]float64{reverseRing(hole)})
+		}
+	}
+	return polygons
+}
+
+// ringGeodesicArea is the area of a Web Mercator ring on the sphere, in square
+// metres, whatever its winding.
+func ringGeodesicArea(ring [][]float64) float64 {
+	area := 0.0
+	for i := range ring {
+		lon1, lat1 := mercatorToLonLat(ring[i][0], ring[i][1])
+		lon2, lat2 := mercatorToLonLat(ring[(i+1)%len(ring)][0], ring[(i+1)%len(ring)][1])
+		area += (lon2 - lon1) * math.Pi / 180 * (2 + math.Sin(lat1*math.Pi/180) + math.Sin(lat2*math.Pi/180))
+	}
+	return math.Abs(area * earthRadius * earthRadius / 2)
+}
+
+// geodesicArea is the area of Web Mercator polygon rings on the sphere, in
+// square metres, with holes taken out. Web Mercator exaggerates areas by
+// about 50% at New Mexico's latitude, so planar areas can't be used.
+func geodesicArea(rings [][][]float64) float64 {
+	area := 0.0
+	for _, polygon := range polygonsFromRings(rings) {
+		area += ringGeodesicArea(polygon[0])
+		for _, hole := range polygon[1:] {
+			area -= ringGeodesicArea(hole)
+		}
+	}
+	return area
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// lonLatRing is a Web Mercator ring through longitude/latitude points.
+func lonLatRing(points ...[2]float64) [][]float64 {
+	ring := make([][]float64, len(points))
+	for i, pt := range points {
+		x, y := lonLatToMercator(pt[0], pt[1])
+		ring[i] = []float64{x, y}
+	}
+	return ring
+}
+
+// box is a closed clockwise Web Mercator ring around a longitude/latitude
+// box, an Esri outer ring. Reverse it for a hole.
+func box(west, south, east, north float64) [][]float64 {
+	return lonLatRing([2]float64{west, south}, [2]float64{west, north}, [2]float64{east, north}, [2]float64{east, south}, [2]float64{west, south})
+}
+
+// boxArea is the exact area on the sphere of a longitude/latitude box.
+func boxArea(west, south, east, north float64) float64 {
+	return earthRadius * earthRadius * (east - west) * math.Pi / 180 * (math.Sin(north*math.Pi/180) - math.Sin(south*math.Pi/180))
+}
+
+func closeTo(got, want, tolerance float64) bool {
+	if want == 0 {
+		return math.Abs(got) <= tolerance
+	}
+	return math.Abs(got-want) <= tolerance*math.Abs(want)
+}
+
+func TestGeodesicArea(t *testing.T) {
+	tests := []struct {
+		name  string
+		rings [][][]float64
+		want  float64
+	}{
+		{"box", [][][]float64{box(-106, 35, -105, 36)}, boxArea(-106, 35, -105, 36)},
+		{"box drawn counter-clockwise", [][][]float64{reverseRing(box(-106, 35, -105, 36))}, boxArea(-106, 35, -105, 36)},
+		{"box with a hole", [][][]float64{box(-106, 35, -105, 36), reverseRing(box(-105.75, 35.25, -105.25, 35.75))}, boxArea(-106, 35, -105, 36) - boxArea(-105.75, 35.25, -105.25, 35.75)},
+		{"two boxes", [][][]float64{box(-106, 35, -105, 36), box(-104, 32, -103, 33)}, boxArea(-106, 35, -105, 36) + boxArea(-104, 32, -103, 33)},
+		{"too few points", [][][]float64{lonLatRing([2]float64{-106, 35}, [2]float64{-105, 36})}, 0},
+		{"no rings", nil, 0},
+	}
+	for _, test := range tests {
+		if got := geodesicArea(test.rings); !closeTo(got, test.want, 1e-9) {
+			t.Errorf("%s: geodesicArea = %f, want %f", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// geoJSONInput is a GeoJSON object posted as an area of interest: a
+// geometry, Feature, FeatureCollection or GeometryCollection. Title and
+// History are the members of the Esri style body, allowed alongside as
+// foreign members, and GeoJSON holds the GeoJSON when it is wrapped in an
+// Esri style body instead.
+type geoJSONInput struct {
+	Type        string                 `json:"type"`
+	Coordinates json.RawMessage        `json:"coordinates"`
+	Geometry    *geoJSONInput          `json:"geometry"`
+	Geometries  []geoJSONInput         `json:"geometries"`
+	Features    []geoJSONInput         `json:"features"`
+	Properties  map[string]interface{} `json:"properties"`
+	CRS         *struct {
+		Properties struct {
+			Name string `json:"name"`
+		} `json:"properties"`
+	} `json:"crs"`
+
+	Title   string        `json:"title"`
+	History bool          `json:"history"`
+	GeoJSON *geoJSONInput `json:"geojson"`
+}
+
+// titleProperties are the feature properties a title is taken from, in order.
+var titleProperties = []string{"title", "Title", "name", "Name", "NAME"}
+
+// geomFromBody reads an area of interest posted to /postgeom or
+// /postgeomforextract. The body is read as GeoJSON when it is sent as
+// application/geo+json, when it has a type member, which the Esri style Geom
+// doesn't, or when the GeoJSON is in a geojson member.
+func geomFromBody(contentType string, body []byte) (Geom, error) {
+	var input geoJSONInput
+	if err := json.Unmarshal(body, &input); err != nil {
+		return Geom{}, err
+	}
+	switch {
+	case input.GeoJSON != nil:
+		geom, err := input.GeoJSON.geom()
+		if input.Title != "" {
+			geom.Title = input.Title
+		}
+		geom.History = input.History
+		return geom, err
+	case strings.HasPrefix(contentType, "application/geo+json") || input.Type != "":
+		geom, err := input.geom()
+		if input.Title != "" {
+			geom.Title = input.Title
+		}
+		geom.History = input.History
+		return geom, err
+	}
+	var geom Geom
+	if err := json.Unmarshal(body, &geom); err != nil {
+		return Geom{}, err
+	}
+	err := geom.toWebMercator()
+	return geom, err
+}
+
+// toWebMercator converts the rings of an Esri style body to Web Mercator, which
+// the areas, overlaps, distances and map are all worked out in. A body in
+// longitude/latitude is projected, and any other spatial reference is an error.
+func (geom *Geom) toWebMercator() error {
+	if geom.SpatialReference == nil {
+		return nil
+	}
+	wkid := geom.SpatialReference.LatestWkid
+	if wkid == 0 {
+		wkid = geom.SpatialReference.Wkid
+	}
+	if wkid == 0 {
+		return nil
+	}
+	mercator, err := crsIsMercator("EPSG:" + strconv.Itoa(wkid))
+	if err != nil {
+		return err
+	}
+	if mercator {
+		return nil
+	}
+	for _, ring := range geom.Rings {
+		for _, pt := range ring {
+			if len(pt) >= 2 {
+				pt[0], pt[1] = lonLatToMercator(pt[0], math.Max(-85, math.Min(85, pt[1])))
+			}
+		}
+	}
+	geom.SpatialReference = nil
+	return nil
+}
+
+// geom converts GeoJSON polygons to an Esri Web Mercator Geom. Every polygon
+// in the object is kept, so a MultiPolygon or a collection of features
+// becomes one area of interest. The title is the first title or name
+// property found on a feature.
+func (g *geoJSONInput) geom() (Geom, error) {
+	var geom Geom
+	mercator := false
+	if g.CRS != nil {
+		var err error
+		if mercator, err = crsIsMercator(g.CRS.Properties.Name); err != nil {
+			return Geom{}, err
+		}
+	}
+	if err := g.addRings(&geom, mercator); err != nil {
+		return Geom{}, err
+	}
+	if len(geom.Rings) == 0 {
+		return Geom{}, errors.New("The GeoJSON has no polygons")
+	}
+	return geom, nil
+}
+
+// addRings appends the polygons of g, and of any features or geometries it
+// holds, to geom.
+func (g *geoJSONInput) addRings(geom *Geom, mercator bool) error {
+	if geom.Title == "" {
+		for _, name := range titleProperties {
+			if title, ok := g.Properties[name].(string); ok && title != "" {
+				geom.Title = title
+				break
+			}
+		}
+	}
+	switch g.Type {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &polygon); err != nil {
+			return err
+		}
+		geom.Rings = append(geom.Rings, esriPolygon(polygon, mercator)...)
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+			return err
+		}
+		for _, polygon := range polygons {
+			geom.Rings = append(geom.Rings, esriPolygon(polygon, mercator)...)
+		}
+	case "Feature":
+		if g.Geometry != nil {
+			return g.Geometry.addRings(geom, mercator)
+		}
+	case "FeatureCollection":
+		for i := range g.Features {
+			if err := g.Features[i].addRings(geom, mercator); err != nil {
+				return err
+			}
+		}
+	case "GeometryCollection":
+		for i := range g.Geometries {
+			if err := g.Geometries[i].addRings(geom, mercator); err != nil {
+				return err
+			}
+		}
+	case "Point", "MultiPoint", "LineString", "MultiLineString":
+		//Only polygons have an area to report on.
+	default:
+		return errors.New("Unknown GeoJSON type " + g.Type)
+	}
+	return nil
+}
+
+// crsIsMercator says whether a GeoJSON 2008 crs name is Web Mercator rather
+// than longitude/latitude. Any other crs is an error, as the coordinates
+// would otherwise be read as the wrong place.
+func crsIsMercator(name string) (bool, error) {
+	for _, code := range []string{"CRS84", "4326"} {
+		if strings.HasSuffix(name, ":"+code) {
+			return false, nil
+		}
+	}
+	for _, code := range []string{"3857", "900913", "102100", "102113"} {
+		if strings.HasSuffix(name, ":"+code) {
+			return true, nil
+		}
+	}
+	return false, errors.New("Unsupported crs " + name + ". The area must be in longitude/latitude (CRS84 or EPSG:4326) or Web Mercator (EPSG:3857)")
+}
+
+// esriPolygon converts one GeoJSON polygon to Esri rings in Web Mercator: the
+// outer ring clockwise and the holes counter-clockwise, each closed. GeoJSON
+// asks for the opposite winding but doesn't insist on it, so the winding is
+// set from each ring's area rather than reversed.
+func esriPolygon(polygon [][][]float64, mercator bool) [][][]float64 {
+	var rings [][][]float64
+	for i, ring := range polygon {
+		if len(ring) < 3 {
+			continue
+		}
+		esri := make([][]float64, 0, len(ring)+1)
+		for _, pt := range ring {
+			if len(pt) < 2 {
+				continue
+			}
+			x, y := pt[0], pt[1]
+			if !mercator {
+				x, y = lonLatToMercator(x, math.Max(-85, math.Min(85, y)))
+			}
+			esri = append(esri, []float64{x, y})
+		}
+		if len(esri) < 3 {
+			continue
+		}
+		if first, last := esri[0], esri[len(esri)-1]; first[0] != last[0] || first[1] != last[1] {
+			esri = append(esri, []float64{first[0], first[1]})
+		}
+		if outer := i == 0; outer == (ringArea(esri) > 0) {
+			esri = reverseRing(esri)
+		}
+		rings = append(rings, esri)
+	}
+	return rings
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGeomFromBody(t *testing.T) {
+	square := `[[[-106,35],[-105,35],[-105,36],[-106,36],[-106,35]]]`
+	area := boxArea(-106, 35, -105, 36)
+	esriSquare := jsonRing(box(-106, 35, -105, 36))
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		rings       int
+		area        float64
+		title       string
+	}{
+		{"Polygon", "", `{"type":"Polygon","coordinates":` + square + `}`, 1, area, ""},
+		{"Polygon as geo+json", "application/geo+json", `{"coordinates":` + square + `,"type":"Polygon"}`, 1, area, ""},
+		{"clockwise Polygon", "", `{"type":"Polygon","coordinates":[[[-106,35],[-106,36],[-105,36],[-105,35],[-106,35]]]}`, 1, area, ""},
+		{"unclosed Polygon", "", `{"type":"Polygon","coordinates":[[[-106,35],[-105,35],[-105,36],[-106,36]]]}`, 1, area, ""},
+		{"Polygon with a hole", "", `{"type":"Polygon","coordinates":[[[-106,35],[-105,35],[-105,36],[-106,36],[-106,35]],[[-105.75,35.25],[-105.75,35.75],[-105.25,35.75],[-105.25,35.25],[-105.75,35.25]]]}`, 2, area - boxArea(-105.75, 35.25, -105.25, 35.75), ""},
+		{"MultiPolygon", "", `{"type":"MultiPolygon","coordinates":[` + square + `,[[[-104,32],[-103,32],[-103,33],[-104,33],[-104,32]]]]}`, 2, area + boxArea(-104, 32, -103, 33), ""},
+		{"Feature", "", `{"type":"Feature","properties":{"name":"Smith's Ranch"},"geometry":{"type":"Polygon","coordinates":` + square + `}}`, 1, area, "Smith's Ranch"},
+		{"FeatureCollection", "", `{"type":"FeatureCollection","features":[{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[-106,35]}},{"type":"Feature","properties":{"NAME":"Unit 4"},"geometry":{"type":"Polygon","coordinates":` + square + `}}]}`, 1, area, "Unit 4"},
+		{"GeometryCollection", "", `{"type":"GeometryCollection","geometries":[{"type":"Polygon","coordinates":` + square + `}]}`, 1, area, ""},
+		{"title overrides name", "", `{"type":"Feature","title":"Mine","properties":{"name":"Theirs"},"geometry":{"type":"Polygon","coordinates":` + square + `}}`, 1, area, "Mine"},
+		{"wrapped in geojson", "", `{"title":"Wrapped","history":true,"geojson":{"type":"Polygon","coordinates":` + square + `}}`, 1, area, "Wrapped"},
+		{"CRS84", "", `{"type":"Polygon","crs":{"type":"name","properties":{"name":"urn:ogc:def:crs:OGC:1.3:CRS84"}},"coordinates":` + square + `}`, 1, area, ""},
+		{"Web Mercator crs", "", `{"type":"Polygon","crs":{"type":"name","properties":{"name":"EPSG:3857"}},"coordinates":[` + jsonRing(reverseRing(box(-106, 35, -105, 36))) + `]}`, 1, area, ""},
+		{"legacy Esri body", "application/json", `{"rings":[` + esriSquare + `],"title":"Drawn"}`, 1, area, "Drawn"},
+		{"Esri body in wkid 102100", "application/json", `{"rings":[` + esriSquare + `],"spatialReference":{"wkid":102100,"latestWkid":3857}}`, 1, area, ""},
+		{"Esri body in wkid 4326", "application/json", `{"rings":[[[-106,35],[-106,36],[-105,36],[-105,35],[-106,35]]],"spatialReference":{"wkid":4326},"title":"Degrees"}`, 1, area, "Degrees"},
+	}
+	for _, test := range tests {
+		geom, err := geomFromBody(test.contentType, []byte(test.body))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(geom.Rings) != test.rings {
+			t.Errorf("%s: %d rings, want %d", test.name, len(geom.Rings), test.rings)
+		}
+		if got := geodesicArea(geom.Rings); !closeTo(got, test.area, 1e-6) {
+			t.Errorf("%s: area %f, want %f", test.name, got, test.area)
+		}
+		if geom.Title != test.title {
+			t.Errorf("%s: title %q, want %q", test.name, geom.Title, test.title)
+		}
+		for i, ring := range geom.Rings {
+			if first, last := ring[0], ring[len(ring)-1]; first[0] != last[0] || first[1] != last[1] {
+				t.Errorf("%s: ring %d is not closed", test.name, i)
+			}
+		}
+		if text := spatialReferenceText(geom); !strings.Contains(text, "Web Mercator") {
+			t.Errorf("%s: spatial reference %s, want Web Mercator", test.name, text)
+		}
+		//Esri outer rings run clockwise and holes counter-clockwise.
+		for _, polygon := range polygonsFromRings(geom.Rings) {
+			for i, ring := range polygon {
+				if outer := i == 0; outer != (ringArea(ring) < 0) {
+					t.Errorf("%s: ring %d of a polygon winds the wrong way", test.name, i)
+				}
+			}
+		}
+	}
+	if geom, _ := geomFromBody("", []byte(`{"rings":[`+esriSquare+`],"title":"Drawn","history":true}`)); !geom.History {
+		t.Errorf("legacy Esri body: history not kept")
+	}
+}
+
+func TestGeomFromBodyErrors(t *testing.T) {
+	square := `[[[-106,35],[-105,35],[-105,36],[-106,36],[-106,35]]]`
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"UTM crs", `{"type":"Polygon","crs":{"type":"name","properties":{"name":"EPSG:26913"}},"coordinates":[[[350000,3900000],[360000,3900000],[360000,3910000],[350000,3900000]]]}`, "Unsupported crs EPSG:26913"},
+		{"crs with no name", `{"type":"Polygon","crs":{"type":"link","properties":{"href":"http://example.com/crs"}},"coordinates":` + square + `}`, "Unsupported crs"},
+		{"only points", `{"type":"Point","coordinates":[-106,35]}`, "no polygons"},
+		{"unknown type", `{"type":"Bogus","coordinates":` + square + `}`, "Unknown GeoJSON type"},
+		{"not JSON", `rings`, "invalid character"},
+		{"Esri body in UTM", `{"rings":[[[350000,3900000],[350000,3910000],[360000,3910000],[350000,3900000]]],"spatialReference":{"wkid":26913}}`, "Unsupported crs EPSG:26913"},
+	}
+	for _, test := range tests {
+		_, err := geomFromBody("", []byte(test.body))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %v, want one containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+// jsonRing writes a ring as JSON coordinates.
+func jsonRing(ring [][]float64) string {
+	points := make([]string, len(ring))
+	for i, pt := range ring {
+		points[i] = "[" + strconv.FormatFloat(pt[0], 'f', -1, 64) + "," + strconv.FormatFloat(pt[1], 'f', -1, 64) + "]"
+	}
+	return "[" + strings.Join(points, ",") + "]"
+}
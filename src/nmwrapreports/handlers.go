@@ -1,9 +1,8 @@
 package main
 
 import (
-	"archive/zip"
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
@@ -11,17 +10,11 @@ import (
 	"fmt"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
-	gdal "github.com/hbarrett/gdal"
-	"github.com/jonas-p/go-shp"
-	"github.com/jung-kurt/gofpdf"
-	"github.com/wcharczuk/go-chart"
 	"github.com/wcharczuk/go-chart/drawing"
 	"html/template"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"reflect"
 	//"reflect"
 	"crypto/sha512"
@@ -381,9 +374,17 @@ type County struct {
 
 //Geom starting struct for geometry
 type Geom struct {
-	Rings   [][][]float64 `json:"rings"`
-	Title   string        `json:"title"`
-	History bool          `json:"history"`
+	Rings            [][][]float64     `json:"rings"`
+	Title            string            `json:"title"`
+	History          bool              `json:"history"`
+	SpatialReference *SpatialReference `json:"spatialReference,omitempty"`
+}
+
+// SpatialReference is an Esri spatial reference. Geometry without one is
+// taken to be in Web Mercator, like the report layers.
+type SpatialReference struct {
+	Wkid       int `json:"wkid"`
+	LatestWkid int `json:"latestWkid,omitempty"`
 }
 
 // GeoJSON for unpacking user supplied geom
@@ -578,13 +579,20 @@ func POSTGeom(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Println(err)
 		}
-		var myGeom Geom
-		json.Unmarshal(jsbody, &myGeom)
-		fmt.Println(myGeom)
-		myRings, _ := json.Marshal(myGeom.Rings)
-		fmt.Println(string(myRings))
+		myGeom, err := geomFromBody(r.Header.Get("Content-Type"), jsbody)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
+		opts, err := ReportOptionsFromJSON(jsbody)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
 
-		fname, err := ReportGen(myGeom, r)
+		fname, err := ReportGen(myGeom, opts, r)
 		logErr(err)
 		fmt.Fprintln(w, fname)
 	} else {
@@ -602,9 +610,12 @@ func POSTGeomForExtract(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Println(err)
 		}
-		var myGeom Geom
-		json.Unmarshal(jsbody, &myGeom)
-		//fmt.Println(myGeom)
+		myGeom, err := geomFromBody(r.Header.Get("Content-Type"), jsbody)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
 		msg, err := ExtractGen(myGeom, r)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -622,339 +633,54 @@ func POSTGeomForExtract(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-//ReportGen - Generate pdf report from geom
-func ReportGen(myGeom Geom, r *http.Request) (string, error) {
-	user := GetCookieParts(r)
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	pdf.SetFont("Helvetica", "", 16)
-	pdf.AddSpotColor("PANTONE 145 CVC", 0, 42, 100, 25)
-	pdf.AddPage()
-	pdf.SetMargins(10, 10, 10)
-	pdf.SetFillSpotColor("PANTONE 145 CVC", 90)
-	pdf.Rect(0, 0, 210, 20, "F")
-	pdf.Image("/var/lib/nmwrapreports/ziafire.png", 2, 2, 16, 16, false, "", 0, "")
-	pdf.SetFont("Helvetica", "", 35)
-	pdf.WriteAligned(0, 0, myGeom.Title, "C")
-	pdf.SetFont("Helvetica", "", 16)
-	CARLow := 0
-	CARMed := 0
-	CARHigh := 0
+//ReportGen - Generate a report from geom in the format asked for in opts
+func ReportGen(myGeom Geom, opts ReportOptions, r *http.Request) (string, error) {
+	return reportGen(r.Context(), GetCookieParts(r), myGeom, opts)
+}
+
+//reportGen - Generate a report for user, giving up when ctx is cancelled
+func reportGen(ctx context.Context, user UserClaims, myGeom Geom, opts ReportOptions) (string, error) {
 	fname, _ := randomFilename()
 	myGeomMarshal, _ := json.Marshal(myGeom)
-	for layernum := 0; layernum < 6; layernum++ {
-		queryurl := "https://edacarc.unm.edu/arcgis/rest/services/NMWRAP/NMWRAP/MapServer/" + strconv.Itoa(layernum) + "/query" //?where=&text=&objectIds=&time=&geometryType=esriGeometryPolygon&inSR=&spatialRel=esriSpatialRelIntersects&relationParam=&outFields=*&returnGeometry=false&returnTrueCurves=false&maxAllowableOffset=&geometryPrecision=&outSR=&returnIdsOnly=false&returnCountOnly=false&orderByFields=&groupByFieldsForStatistics=&outStatistics=&returnZ=false&returnM=false&gdbVersion=&returnDistinctValues=false&resultOffset=&resultRecordCount=&f=pjson&geometry="
-		resp, err := http.PostForm(queryurl, url.Values{
-			"f":                    {"pjson"},
-			"geometry":             {string(myGeomMarshal)},
-			"geometryType":         {"esriGeometryPolygon"},
-			"outFields":            {"*"},
-			"returnCountOnly":      {"false"},
-			"returnDistinctValues": {"false"},
-			"returnGeometry":       {"false"},
-			"returnIdsOnly":        {"false"},
-			"returnM":              {"false"},
-			"returnTrueCurves":     {"false"},
-			"returnZ":              {"false"},
-			"spatialRel":           {"esriSpatialRelIntersects"}})
-
-		if err != nil {
-			log.Println(err)
-		}
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Println(err)
+	sections := ReportSections()
+	setArea(myGeom, opts.areaUnit(), sections)
+	queries := make([]SectionQuery, len(sections))
+	for i, section := range sections {
+		queries[i] = section.Query()
+		queries[i].ReturnGeometry = queries[i].ReturnGeometry || opts.needsGeometry()
+	}
+	results := queryLayers(ctx, myGeomMarshal, queries)
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	for i, section := range sections {
+		if results[i].Status != LayerOK {
+			log.Println(section.Title()+": ", results[i].Err)
+			continue
 		}
-		switch lnum := layernum; lnum {
-		case 0:
-			pdf.Ln(15)
-			pdf.SetFont("Helvetica", "", 20)
-			pdf.CellFormat(190, 15, "Fire stations", "0", 1, "CM", false, 0, "")
-			pdf.SetFont("Helvetica", "", 11)
-			var myJSON FireStations
-			json.Unmarshal(body, &myJSON)
-			FireStationsBlurb := "There are " + strconv.Itoa(len(myJSON.Features)) + " fire stations in this area. The proximity of fire stations is essential to an assessment of fire safety. Lorem ipsum dolor sit amet, consectetur adipiscing elit.\nInteger nec odio. Praesent libero. Sed cursus ante dapibus diam. Sed nisi. Nulla quis sem at nibh elementum imperdiet. Duis sagittis ipsum. Praesent mauris. Fusce nec tellus sed augue semper porta. Mauris massa. Vestibulum lacinia arcu eget nulla. Class aptent taciti sociosqu ad litora torquent per conubia nostra, per inceptos himenaeos. Curabitur sodales ligula in libero."
-			lines := pdf.SplitLines([]byte(FireStationsBlurb), 190.0)
-			_, lineHt := pdf.GetFontSize()
-
-			for _, line := range lines {
-				pdf.CellFormat(190.0, lineHt, string(line), "", 1, "TL", false, 0, "")
-			}
-			if len(myJSON.Features) > 0 {
-				pdf.CellFormat(0, 5, "", "3", 1, "", false, 0, "")
-				pdf.SetFont("Helvetica", "", 12)
-				pdf.CellFormat(45, 7, "Address", "1", 0, "C", true, 0, "")
-				pdf.CellFormat(29, 7, "City", "1", 0, "C", true, 0, "")
-				pdf.CellFormat(116, 7, "Name", "1", 0, "C", true, 0, "")
-				pdf.Ln(-1)
-				pdf.SetFont("Helvetica", "", 6)
-			}
-			for _, element := range myJSON.Features {
-				pdf.CellFormat(45, 7, element.Attributes.ADDRESS, "1", 0, "", false, 0, "")
-				pdf.CellFormat(29, 7, element.Attributes.CITY, "1", 0, "", false, 0, "")
-				pdf.CellFormat(116, 7, element.Attributes.INSTNAME, "1", 0, "", false, 0, "")
-				pdf.Ln(-1)
-			}
-
-		case 1:
-			pdf.Ln(15)
-			pdf.SetFont("Helvetica", "", 20)
-			pdf.CellFormat(190, 15, "Communities At Risk", "0", 1, "CM", false, 0, "")
-
-			pdf.Ln(5)
-
-			var myJSON CommunitesatRisk
-			pdf.SetFont("Helvetica", "", 11)
-			json.Unmarshal(body, &myJSON)
-			CommunitesatRiskBlurb := strconv.Itoa(len(myJSON.Features)) + " communites at risk were found in this area. Lorem ipsum dolor sit amet, consectetur adipiscing elit.\nInteger nec odio. Praesent libero. Sed cursus ante dapibus diam. Sed nisi. Nulla quis sem at nibh elementum imperdiet. Duis sagittis ipsum. Praesent mauris. Fusce nec tellus sed augue semper porta. Mauris massa. Vestibulum lacinia arcu eget nulla. Class aptent taciti sociosqu ad litora torquent per conubia nostra, per inceptos himenaeos. Curabitur sodales ligula in libero."
-			lines := pdf.SplitLines([]byte(CommunitesatRiskBlurb), 190.0)
-			_, lineHt := pdf.GetFontSize()
-			for _, line := range lines {
-				pdf.CellFormat(190.0, lineHt, string(line), "", 1, "TL", false, 0, "")
-			}
-			if len(myJSON.Features) > 0 {
-				pdf.Ln(3)
-				pdf.SetFont("Helvetica", "", 12)
-				pdf.CellFormat(64, 7, "Name", "1", 0, "C", true, 0, "")
-				pdf.CellFormat(63, 7, "County", "1", 0, "C", true, 0, "")
-				pdf.CellFormat(63, 7, "Rate", "1", 0, "C", true, 0, "")
-				pdf.Ln(-1)
-			}
-			pdf.SetFont("Helvetica", "", 6)
-
-			json.Unmarshal(body, &myJSON)
-
-			for _, element := range myJSON.Features {
-				rate := ""
-				if element.Attributes.Rate2016 == "L" {
-					rate = "Low Risk"
-					CARLow++
-				} else if element.Attributes.Rate2016 == "M" {
-					rate = "Medium Risk"
-					CARMed++
-				} else if element.Attributes.Rate2016 == "H" {
-					rate = "High Risk"
-					CARHigh++
-				}
-				pdf.CellFormat(64, 7, element.Attributes.NAME, "1", 0, "", false, 0, "")
-				pdf.CellFormat(63, 7, element.Attributes.NAME1, "1", 0, "", false, 0, "")
-				pdf.CellFormat(63, 7, rate, "1", 0, "", false, 0, "")
-				pdf.Ln(-1)
-			}
-			type ColorPaletteRed interface {
-				BackgroundColor() drawing.Color
-				BackgroundStrokeColor() drawing.Color
-				CanvasColor() drawing.Color
-				CanvasStrokeColor() drawing.Color
-				AxisStrokeColor() drawing.Color
-				TextColor() drawing.Color
-				GetSeriesColor(index int) drawing.Color
-			}
-
-			carsummation := 0
-			if CARLow > 0 {
-				carsummation = carsummation + 1
-			}
-			if CARMed > 0 {
-				carsummation = carsummation + 1
-			}
-			if CARHigh > 0 {
-				carsummation = carsummation + 1
-			}
-			if carsummation > 0 {
-				pie := chart.PieChart{
-					Title: "Communities At Risk",
-					// ColorPalette : colorpal ,
-					Width:  512,
-					Height: 512,
-					Values: []chart.Value{
-						{Value: float64(CARHigh), Label: strconv.Itoa(CARHigh) + " High Risk"},
-						{Value: float64(CARMed), Label: strconv.Itoa(CARMed) + " Medium Risk"},
-						{Value: float64(CARLow), Label: strconv.Itoa(CARLow) + " Low Risk"},
-					},
-				}
-
-				buffer := bytes.NewBuffer([]byte{})
-				err = pie.Render(chart.PNG, buffer)
-				if err != nil {
-					fmt.Printf("Error rendering pie chart: %v\n", err)
-				}
-				piereader := bufio.NewReader(buffer)
-
-				var options gofpdf.ImageOptions
-				options.ImageType = "PNG"
-
-				pdf.RegisterImageOptionsReader("piechart", options, piereader)
-
-				whatwegot := 297.0
-				whatweneed := pdf.GetY() + 128
-
-				if whatweneed > whatwegot {
-					extrapadding := 297.0 - pdf.GetY()
-
-					pdf.CellFormat(10, extrapadding, "", "0", 0, "", false, 0, "")
-
-				}
-				CurrentX := pdf.GetX()
-				CurrentY := pdf.GetY()
-				if pdf.Ok() {
-
-					pdf.Image("piechart", CurrentX+31, CurrentY, 128, 128, false, "", 0, "")
-
-					pdf.SetY(CurrentY + 128)
-				}
-
-			}
-
-		case 2:
-			pdf.Ln(15)
-			pdf.SetFont("Helvetica", "", 20)
-			pdf.CellFormat(190, 15, "Incorporated City Boundaries", "0", 1, "CM", false, 0, "")
-
-			pdf.Ln(5)
-
-			pdf.CellFormat(0, 5, "", "3", 1, "", false, 0, "")
-			var myJSON IncorporatedCityBoundaries
-			pdf.SetFont("Helvetica", "", 11)
-			json.Unmarshal(body, &myJSON)
-			IncorporatedCityBoundariesBlurb := "Incorporated City Boundaries Blurb Lorem ipsum dolor sit amet, consectetur adipiscing elit.\nInteger nec odio. Praesent libero. Sed cursus ante dapibus diam. Sed nisi. Nulla quis sem at nibh elementum imperdiet. Duis sagittis ipsum. Praesent mauris. Fusce nec tellus sed augue semper porta. Mauris massa. Vestibulum lacinia arcu eget nulla. Class aptent taciti sociosqu ad litora torquent per conubia nostra, per inceptos himenaeos. Curabitur sodales ligula in libero."
-			lines := pdf.SplitLines([]byte(IncorporatedCityBoundariesBlurb), 190.0)
-			_, lineHt := pdf.GetFontSize()
-			for _, line := range lines {
-				pdf.CellFormat(190.0, lineHt, string(line), "", 1, "TL", false, 0, "")
-			}
-			if len(myJSON.Features) > 0 {
-				pdf.Ln(3)
-				pdf.SetFont("Helvetica", "", 12)
-				pdf.CellFormat(95, 7, "Name", "1", 0, "C", true, 0, "")
-				pdf.CellFormat(95, 7, "Area", "1", 0, "C", true, 0, "")
-
-				pdf.Ln(-1)
-			}
-			pdf.SetFont("Helvetica", "", 6)
-
-			json.Unmarshal(body, &myJSON)
-
-			for _, element := range myJSON.Features {
-				pdf.CellFormat(95, 7, element.Attributes.NAME10, "1", 0, "", false, 0, "")
-				pdf.CellFormat(95, 7, strconv.FormatFloat(element.Attributes.ShapeArea, 'E', -1, 64), "1", 0, "", false, 0, "")
-
-				pdf.Ln(-1)
-			}
-		case 3:
-			//
-			pdf.Ln(15)
-			pdf.SetFont("Helvetica", "", 20)
-			pdf.CellFormat(190, 15, "Vegetation Treatments", "0", 1, "CM", false, 0, "")
-
-			pdf.Ln(5)
-
-			var myJSON VegetationTreatments
-			pdf.SetFont("Helvetica", "", 11)
-			json.Unmarshal(body, &myJSON)
-			VegetationTreatmentsBlurb := "Vegetation Treatments Blurb Lorem ipsum dolor sit amet, consectetur adipiscing elit.\nInteger nec odio. Praesent libero. Sed cursus ante dapibus diam. Sed nisi. Nulla quis sem at nibh elementum imperdiet. Duis sagittis ipsum. Praesent mauris. Fusce nec tellus sed augue semper porta. Mauris massa. Vestibulum lacinia arcu eget nulla. Class aptent taciti sociosqu ad litora torquent per conubia nostra, per inceptos himenaeos. Curabitur sodales ligula in libero."
-			lines := pdf.SplitLines([]byte(VegetationTreatmentsBlurb), 190.0)
-			_, lineHt := pdf.GetFontSize()
-			for _, line := range lines {
-				pdf.CellFormat(190.0, lineHt, string(line), "", 1, "TL", false, 0, "")
-			}
-			if len(myJSON.Features) > 0 {
-				pdf.Ln(3)
-				pdf.SetFont("Helvetica", "", 12)
-				pdf.CellFormat(64, 7, "Description", "1", 0, "C", true, 0, "")
-				pdf.CellFormat(63, 7, "NameProj", "1", 0, "C", true, 0, "")
-				pdf.CellFormat(63, 7, "Partners", "1", 0, "C", true, 0, "")
-				pdf.Ln(-1)
-			}
-			pdf.SetFont("Helvetica", "", 6)
-
-			json.Unmarshal(body, &myJSON)
-
-			for _, element := range myJSON.Features {
-				pdf.CellFormat(64, 7, element.Attributes.Description, "1", 0, "", false, 0, "")
-				pdf.CellFormat(63, 7, element.Attributes.NameProj, "1", 0, "", false, 0, "")
-				pdf.CellFormat(63, 7, element.Attributes.Partners, "1", 0, "", false, 0, "")
-
-				pdf.Ln(-1)
-			}
-		case 4:
-			//
-			pdf.Ln(15)
-			pdf.SetFont("Helvetica", "", 20)
-			pdf.CellFormat(190, 15, "Watersheds HUC8", "0", 1, "CM", false, 0, "")
-
-			pdf.Ln(5)
-
-			var myJSON WatershedsHUC8
-			pdf.SetFont("Helvetica", "", 11)
-			json.Unmarshal(body, &myJSON)
-			WatershedsHUC8Blurb := "Watersheds HUC8 Blurb Lorem ipsum dolor sit amet, consectetur adipiscing elit.\nInteger nec odio. Praesent libero. Sed cursus ante dapibus diam. Sed nisi. Nulla quis sem at nibh elementum imperdiet. Duis sagittis ipsum. Praesent mauris. Fusce nec tellus sed augue semper porta. Mauris massa. Vestibulum lacinia arcu eget nulla. Class aptent taciti sociosqu ad litora torquent per conubia nostra, per inceptos himenaeos. Curabitur sodales ligula in libero."
-			lines := pdf.SplitLines([]byte(WatershedsHUC8Blurb), 200.0)
-			_, lineHt := pdf.GetFontSize()
-
-			for _, line := range lines {
-				pdf.CellFormat(190.0, lineHt, string(line), "", 1, "TL", false, 0, "")
-			}
-			if len(myJSON.Features) > 0 {
-				pdf.Ln(3)
-				pdf.SetFont("Helvetica", "", 12)
-				pdf.CellFormat(64, 7, "Name", "1", 0, "C", true, 0, "")
-				pdf.CellFormat(63, 7, "TNC Ranking", "1", 0, "C", true, 0, "")
-				pdf.CellFormat(63, 7, "State", "1", 0, "C", true, 0, "")
-				pdf.Ln(-1)
-				pdf.SetFont("Helvetica", "", 6)
-			}
-
-			pdf.SetFont("Helvetica", "", 6)
-
-			json.Unmarshal(body, &myJSON)
-
-			for _, element := range myJSON.Features {
-				pdf.CellFormat(64, 7, element.Attributes.NAME, "1", 0, "", false, 0, "")
-				pdf.CellFormat(63, 7, strconv.Itoa(element.Attributes.TNCRanking), "1", 0, "", false, 0, "")
-				pdf.CellFormat(63, 7, element.Attributes.STATES, "1", 0, "", false, 0, "")
-
-				pdf.Ln(-1)
-			}
-		case 5:
-			//County
-			pdf.Ln(15)
-			pdf.SetFont("Helvetica", "", 20)
-			pdf.CellFormat(190, 15, "Counties with intersecting data", "0", 1, "CM", false, 0, "")
-
-			pdf.Ln(5)
-
-			var myJSON County
-			pdf.SetFont("Helvetica", "", 11)
-			json.Unmarshal(body, &myJSON)
-			CountyBlurb := "County Blurb Lorem ipsum dolor sit amet, consectetur adipiscing elit.\nInteger nec odio. Praesent libero. Sed cursus ante dapibus diam. Sed nisi. Nulla quis sem at nibh elementum imperdiet. Duis sagittis ipsum. Praesent mauris. Fusce nec tellus sed augue semper porta. Mauris massa. Vestibulum lacinia arcu eget nulla. Class aptent taciti sociosqu ad litora torquent per conubia nostra, per inceptos himenaeos. Curabitur sodales ligula in libero."
-			lines := pdf.SplitLines([]byte(CountyBlurb), 190.0)
-			_, lineHt := pdf.GetFontSize()
-			for _, line := range lines {
-				pdf.CellFormat(190.0, lineHt, string(line), "", 1, "TL", false, 0, "")
-			}
-			if len(myJSON.Features) > 0 {
-				pdf.Ln(3)
-				pdf.SetFont("Helvetica", "", 12)
-				pdf.CellFormat(190, 7, "County", "1", 0, "C", true, 0, "")
-
-				pdf.Ln(-1)
-			}
-			pdf.SetFont("Helvetica", "", 6)
-
-			json.Unmarshal(body, &myJSON)
-
-			for _, element := range myJSON.Features {
-				pdf.CellFormat(190, 7, element.Attributes.NAMELSAD, "1", 0, "", false, 0, "")
-
-				pdf.Ln(-1)
-			}
-		default:
+		if err := section.Decode(results[i].Body); err != nil {
+			log.Println(section.Title()+": ", err)
+			results[i].Status = LayerUnreadable
+			results[i].Err = err
 		}
-
 	}
+	applyOverlaps(myGeom, opts.areaUnit(), sections, results)
+	sources := layerSources(sections, queries, results)
+	report := Report{
+		ID:        fname,
+		Title:     myGeom.Title,
+		Requester: user.Name,
+		Generated: time.Now(),
+		Sections:  reportContent(myGeom, opts.areaUnit(), sections, results, sources),
+		Records:   reportRecords(sections, results),
+		Layers:    reportLayers(sections, results),
+		AOI:       myGeom,
+		Units:     opts.areaUnit(),
+		Sources:   sources,
+	}
+	writer := reportWriters[opts.Format]
 
-	err := pdf.OutputFileAndClose("/tmp/" + fname + ".pdf")
+	err := writer.Write(report, "/tmp/"+fname+writer.Ext)
 	if err != nil {
 		log.Println(err)
 	} else {
@@ -986,16 +712,14 @@ func ReportGen(myGeom Geom, r *http.Request) (string, error) {
 			}
 
 		}
-		log.Println(json.Marshal(myGeom))
-		log.Println(myGeom.History)
 		if myGeom.History != true {
 			geomtitle := myGeom.Title
 			marstring, _ := json.Marshal(myGeom)
-			AreaQuery := "INSERT INTO areasofinterest (userid,geom,title)  VALUES ('" + strconv.Itoa(id) + "','" + string(marstring) + "','" + geomtitle + "');"
-			log.Println(AreaQuery)
-			_, err = db.Exec(AreaQuery)
+			//Titles come from uploaded files, so they go in as parameters, never as SQL.
+			_, err = db.Exec("INSERT INTO areasofinterest (userid,geom,title) VALUES (?,?,?)", id, string(marstring), geomtitle)
 			if err != nil {
-				log.Fatal(err)
+				//The report is written, so it is still returned.
+				return fname, err
 			}
 		}
 		return fname, nil
@@ -1007,8 +731,8 @@ func ReportGen(myGeom Geom, r *http.Request) (string, error) {
 func GetReport(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
-	fullpath := "/tmp/" + key + ".pdf"
 	fname := vars["fname"]
+	fullpath := reportPath(key, fname)
 	w.Header().Set("Content-Disposition", "attachment; filename="+fname)
 	w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
 	http.ServeFile(w, r, fullpath)
@@ -1055,93 +779,75 @@ func (dp defaultColorPalette) CanvasColorRed() drawing.Color {
 	return DefaultCanvasColor
 }
 
-//GetReportFromUpload - preprocesses geom for ReportGen
+//GetReportFromUpload - preprocesses geom for ReportGen, or starts a batch of
+//reports, one per feature, when the batch form field is true.
 func GetReportFromUpload(w http.ResponseWriter, r *http.Request) {
 	if IsLoggedIn(r) {
-		AllowdShapeExtensions := []string{"cpg", "dbf", "prj", "sbn", "sbx", "shp", "shx"}
-		file, _, err := r.FormFile("file")
-		if err != nil {
-			panic(err)
+		if batch, _ := strconv.ParseBool(r.FormValue("batch")); batch {
+			batchReportFromUpload(w, r)
+			return
 		}
-		defer file.Close()
-		RandomFileName := RandString(10)
-		ZipFile := "/tmp/" + RandomFileName + ".zip"
-		out, err := os.Create(ZipFile)
+		myGeom, used, err := uploadGeom(r)
 		if err != nil {
-			fmt.Fprintf(w, "Unable to create the file for writing. Check your write access privilege")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
 			return
 		}
-		defer out.Close()
-		_, err = io.Copy(out, file)
+		w.Header().Set(uploadFeaturesHeader, used)
+		opts, err := ReportOptionsFromForm(r)
 		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintln(w, err)
+			return
 		}
-		reader, err := zip.OpenReader(ZipFile)
-		if err != nil {
+		fname, err := ReportGen(myGeom, opts, r)
+		logErr(err)
+		fmt.Fprintln(w, fname)
+		fmt.Fprintln(w, "Features used: "+used)
 
-			log.Fatal(err)
+	} else {
+		return
+	}
+}
 
+//ReportJob shows the progress of a batch of reports as JSON.
+func ReportJob(w http.ResponseWriter, r *http.Request) {
+	if IsLoggedIn(r) {
+		job, ok := findBatchJob(mux.Vars(r)["id"], GetCookieParts(r).EMail)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "No such report job")
+			return
 		}
-		ShapeName := ""
-		defer reader.Close()
-		dest := "/tmp/" + RandomFileName
-		os.MkdirAll(dest, 755)
-		for _, f := range reader.File {
-			//	fmt.Println(f.Name)
-			extension := strings.Split(f.Name, ".")
-			path := dest + "/" + f.Name
-			if stringInSlice(extension[1], AllowdShapeExtensions) {
-				if extension[1] == "shp" {
-					ShapeName = f.Name
-				}
-
-				rc, err := f.Open()
-				logErr(err)
-				defer rc.Close()
-				f, err := os.OpenFile(
-					path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-				logErr(err)
-				defer f.Close()
+		jobjson, _ := json.Marshal(job)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jobjson)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "You must be logged in!")
+	}
+}
 
-				_, err = io.Copy(f, rc)
-				logErr(err)
-			}
+//GetBatchReport downloads the ZIP of a finished batch of reports.
+func GetBatchReport(w http.ResponseWriter, r *http.Request) {
+	if IsLoggedIn(r) {
+		job, ok := findBatchJob(mux.Vars(r)["id"], GetCookieParts(r).EMail)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "No such report job")
+			return
 		}
-		Shapefile := "/tmp/" + RandomFileName + "/" + ShapeName
-		myshape, err := shp.Open(Shapefile)
-		if err != nil {
-			log.Fatal(err)
+		if job.Status != "done" {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintln(w, "The report job is "+job.Status)
+			return
 		}
-		defer myshape.Close()
-		driver := gdal.OGRDriverByName("ESRI Shapefile")
-		fmt.Println(driver)
-		datasource, _ := driver.Open(Shapefile, 0)
-		fmt.Println(datasource.LayerCount())
-		layer := datasource.LayerByIndex(0)
-		myfeature := layer.Feature(0)
-		geom := myfeature.Geometry()
-		spatialRef := gdal.CreateSpatialReference("PROJCS[\"WGS 84 / Pseudo-Mercator\",GEOGCS[\"WGS 84\",DATUM[\"WGS_1984\",SPHEROID[\"WGS 84\",6378137,298.257223563,AUTHORITY[\"EPSG\",\"7030\"]],AUTHORITY[\"EPSG\",\"6326\"]],PRIMEM[\"Greenwich\",0,AUTHORITY[\"EPSG\",\"8901\"]],UNIT[\"degree\",0.0174532925199433,AUTHORITY[\"EPSG\",\"9122\"]],AUTHORITY[\"EPSG\",\"4326\"]],PROJECTION[\"Mercator_1SP\"],PARAMETER[\"central_meridian\",0],PARAMETER[\"scale_factor\",1],PARAMETER[\"false_easting\",0],PARAMETER[\"false_northing\",0],UNIT[\"metre\",1,AUTHORITY[\"EPSG\",\"9001\"]],AXIS[\"X\",EAST],AXIS[\"Y\",NORTH],EXTENSION[\"PROJ4\",\"+proj=merc +a=6378137 +b=6378137 +lat_ts=0.0 +lon_0=0.0 +x_0=0.0 +y_0=0 +k=1.0 +units=m +nadgrids=@null +wktext  +no_defs\"],AUTHORITY[\"EPSG\",\"3857\"]]")
-		geom.TransformTo(spatialRef)
-		fmt.Println(geom.ToWKT())
-		// fmt.Println(geom.ToGML())
-		fmt.Println(geom.ToJSON())
-		//fmt.Println(geom.ToKML())
-		fmt.Println("ZZZ")
-		fmt.Println(geom)
-		var myGeoJSON GeoJSON
-		json.Unmarshal([]byte(geom.ToJSON()), &myGeoJSON)
-		//	fmt.Println(reflect.TypeOf(myGeoJSON.Coordinates[0]))
-		var myGeom Geom
-		myGeom.Title = r.FormValue("title")
-		myGeom.Rings = myGeoJSON.Coordinates
-		fmt.Println(myGeom)
-		fname, err := ReportGen(myGeom, r)
-		logErr(err)
-		fmt.Println(fname)
-		fmt.Fprintln(w, fname)
-
+		w.Header().Set("Content-Disposition", "attachment; filename=reports-"+job.ID+".zip")
+		w.Header().Set("Content-Type", "application/zip")
+		http.ServeFile(w, r, batchPath(job.ID))
 	} else {
-		return
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "You must be logged in!")
 	}
 }
 
@@ -1299,82 +1005,13 @@ func ExtractJobs() {
 //ExtractFromUpload - Pre-process geom from shp file for ExtractGen
 func ExtractFromUpload(w http.ResponseWriter, r *http.Request) {
 	if IsLoggedIn(r) {
-		AllowdShapeExtensions := []string{"cpg", "dbf", "prj", "sbn", "sbx", "shp", "shx"}
-
-		file, _, err := r.FormFile("file")
-		if err != nil {
-			panic(err)
-		}
-		defer file.Close()
-		RandomFileName := RandString(10)
-		ZipFile := "/tmp/" + RandomFileName + ".zip"
-		out, err := os.Create(ZipFile)
-		if err != nil {
-			fmt.Fprintf(w, "Unable to create the file for writing. Check your write access privilege")
-			return
-		}
-		defer out.Close()
-		_, err = io.Copy(out, file)
+		myGeom, used, err := uploadGeom(r)
 		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintln(w, err)
+			return
 		}
-		reader, err := zip.OpenReader(ZipFile)
-		if err != nil {
-
-			log.Fatal(err)
-
-		}
-		ShapeName := ""
-		defer reader.Close()
-		dest := "/tmp/" + RandomFileName
-		os.MkdirAll(dest, 755)
-		for _, f := range reader.File {
-			extension := strings.Split(f.Name, ".")
-			path := dest + "/" + f.Name
-			if stringInSlice(extension[1], AllowdShapeExtensions) {
-				if extension[1] == "shp" {
-					ShapeName = f.Name
-				}
-
-				rc, err := f.Open()
-				logErr(err)
-				defer rc.Close()
-				f, err := os.OpenFile(
-					path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-				logErr(err)
-				defer f.Close()
-
-				_, err = io.Copy(f, rc)
-				logErr(err)
-			}
-		}
-		Shapefile := "/tmp/" + RandomFileName + "/" + ShapeName
-		myshape, err := shp.Open(Shapefile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer myshape.Close()
-		driver := gdal.OGRDriverByName("ESRI Shapefile")
-		fmt.Println(driver)
-		datasource, _ := driver.Open(Shapefile, 0)
-		fmt.Println(datasource.LayerCount())
-		layer := datasource.LayerByIndex(0)
-		myfeature := layer.Feature(0)
-		geom := myfeature.Geometry()
-		spatialRef := gdal.CreateSpatialReference("PROJCS[\"WGS 84 / Pseudo-Mercator\",GEOGCS[\"WGS 84\",DATUM[\"WGS_1984\",SPHEROID[\"WGS 84\",6378137,298.257223563,AUTHORITY[\"EPSG\",\"7030\"]],AUTHORITY[\"EPSG\",\"6326\"]],PRIMEM[\"Greenwich\",0,AUTHORITY[\"EPSG\",\"8901\"]],UNIT[\"degree\",0.0174532925199433,AUTHORITY[\"EPSG\",\"9122\"]],AUTHORITY[\"EPSG\",\"4326\"]],PROJECTION[\"Mercator_1SP\"],PARAMETER[\"central_meridian\",0],PARAMETER[\"scale_factor\",1],PARAMETER[\"false_easting\",0],PARAMETER[\"false_northing\",0],UNIT[\"metre\",1,AUTHORITY[\"EPSG\",\"9001\"]],AXIS[\"X\",EAST],AXIS[\"Y\",NORTH],EXTENSION[\"PROJ4\",\"+proj=merc +a=6378137 +b=6378137 +lat_ts=0.0 +lon_0=0.0 +x_0=0.0 +y_0=0 +k=1.0 +units=m +nadgrids=@null +wktext  +no_defs\"],AUTHORITY[\"EPSG\",\"3857\"]]")
-		geom.TransformTo(spatialRef)
-		fmt.Println(geom.ToWKT())
-		// fmt.Println(geom.ToGML())
-		fmt.Println(geom.ToJSON())
-		//fmt.Println(geom.ToKML())
-		fmt.Println("ZZZ")
-		fmt.Println(geom)
-		var myGeoJSON GeoJSON
-		json.Unmarshal([]byte(geom.ToJSON()), &myGeoJSON)
-		var myGeom Geom
-		myGeom.Title = r.FormValue("title")
-		myGeom.Rings = myGeoJSON.Coordinates
-
+		w.Header().Set(uploadFeaturesHeader, used)
 		msg, err := ExtractGen(myGeom, r)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -1383,6 +1020,7 @@ func ExtractFromUpload(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprintln(w, msg)
 		}
+		fmt.Fprintln(w, "Features used: "+used)
 
 	} else {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -1399,7 +1037,6 @@ func ExtractGen(myGeom Geom, r *http.Request) (string, error) {
 		return "Could not Unmarshal JSON Request.", errors.New("Could not Unmarshal JSON Request")
 	}
 	myRings, _ := json.Marshal(myGeom.Rings)
-	fmt.Println(string(myRings))
 	thisuser, err := UserData(r)
 	aoi := "{\"features\":[{\"geometry\":{\"rings\":" + string(myRings) + "}}]}"
 	queryurl := "https://edacarc.unm.edu/arcgis/rest/services/NMWRAP/ExtractData/GPServer/Extract%20Data/submitJob"
@@ -1443,15 +1080,12 @@ func ExtractGen(myGeom Geom, r *http.Request) (string, error) {
 			log.Fatal(err)
 			return "Failed to insert job into DB.", err
 		}
-		log.Println(myGeom.History)
 		if myGeom.History != true {
 			geomtitle := myGeom.Title
 			marstring, _ := json.Marshal(myGeom)
-			AreaQuery := "INSERT INTO areasofinterest (userid,geom,title)  VALUES ('" + thisuser["id"] + "','" + string(marstring) + "','" + geomtitle + "');"
-			log.Println(AreaQuery)
-			_, err = db.Exec(AreaQuery)
+			_, err = db.Exec("INSERT INTO areasofinterest (userid,geom,title) VALUES (?,?,?)", thisuser["id"], string(marstring), geomtitle)
 			if err != nil {
-				log.Fatal(err)
+				return "Extract task submitted, but the area could not be saved to your history.", err
 			}
 		}
 		return "Extract task submitted. An e-mail will be sent to " + thisuser["email"] + " with job status updates.", nil
@@ -2122,3 +2122,65 @@ font-size: 0px; } }
 
 
 </body></html>`
+
+//ReportTmpl is the self contained HTML version of a report.
+const ReportTmpl string = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+{{.Theme.FontFace}}
+@page { {{.Theme.Page}} }
+body { font-family: {{.Theme.FontFamily}}; margin: 0; color: #333333; }
+header { background: {{.Theme.HeaderColor}}; height: 76px; position: relative; }
+header img { position: absolute; left: 8px; top: 8px; width: 60px; height: 60px; }
+header h1 { margin: 0; line-height: 76px; text-align: center; font-size: 32px; font-weight: normal; }
+main { max-width: 760px; margin: 0 auto; padding: 0 20px 40px 20px; }
+h2 { text-align: center; font-weight: normal; font-size: 24px; margin-top: 48px; }
+p { font-size: 14px; line-height: 1.4; white-space: pre-line; }
+table { width: 100%; border-collapse: collapse; margin-top: 12px; font-size: 12px; }
+th, td { border: 1px solid #333333; padding: 4px; text-align: left; }
+th { background: {{.Theme.HeaderColor}}; font-weight: normal; text-align: center; }
+tbody tr:nth-child(even) { background: #f2f2f2; }
+thead { display: table-header-group; }
+tr { page-break-inside: avoid; }
+caption { text-align: left; font-size: 14px; padding: 16px 0 4px 0; }
+figure { text-align: center; margin: 16px 0; }
+figure img { max-width: 100%; }
+footer { text-align: center; font-size: 11px; padding: 12px 0; }
+dl.cover { display: grid; grid-template-columns: 1fr 1fr; gap: 6px 20px; margin: 32px 0; }
+dl.cover dt { text-align: right; }
+dl.cover dd { margin: 0; }
+nav ol { font-size: 14px; line-height: 1.8; }
+</style>
+</head>
+<body>
+<header>{{if .Logo}}<img src="{{.Logo}}" alt="">{{end}}<h1>{{.Title}}</h1></header>
+<main>
+<dl class="cover">
+<dt>Area of interest</dt><dd>{{.AreaText}}</dd>
+{{if .Requester}}<dt>Prepared for</dt><dd>{{.Requester}}</dd>
+{{end}}<dt>Generated</dt><dd>{{.GeneratedText}}</dd>
+<dt>Report ID</dt><dd>{{.ID}}</dd>
+</dl>
+<nav>
+<h2>Contents</h2>
+<ol>{{range $i, $section := .Sections}}<li><a href="#section-{{$i}}">{{$section.Title}}</a></li>{{end}}</ol>
+</nav>
+{{range $i, $section := .Sections}}<section id="section-{{$i}}">
+<h2>{{.Title}}</h2>
+<p>{{.Blurb}}</p>
+{{range .Tables}}{{if .Rows}}{{$widths := .Widths}}{{$table := .}}<table>
+{{if .Caption}}<caption>{{.Caption}}</caption>
+{{end}}<colgroup>{{range .Widths}}<col style="{{colwidth . $widths}}">{{end}}</colgroup>
+<thead><tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>{{range .Rows}}<tr>{{range $i, $cell := .}}<td style="{{cellalign $table $i}}">{{$cell}}</td>{{end}}</tr>
+{{end}}</tbody></table>
+{{end}}{{end}}{{range .Charts}}<figure><img src="{{datauri .PNG}}" alt="{{.Name}}" width="{{pixels .Width}}"></figure>
+{{end}}{{range .Notes}}<p>{{.}}</p>
+{{end}}</section>
+{{end}}</main>
+{{if .Theme.FooterText}}<footer>{{.Theme.FooterText}}</footer>
+{{end}}</body>
+</html>`
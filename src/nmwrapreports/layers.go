@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLayerService is the NMWRAP MapServer used when the config does not name one.
+const defaultLayerService = "https://edacarc.unm.edu/arcgis/rest/services/NMWRAP/NMWRAP/MapServer/"
+
+// CatalogLayer is one MapServer layer that appears in the report.
+type CatalogLayer struct {
+	// Section is the registered ReportSection used to render the layer.
+	// Layers without a registered section are rendered as a plain table of Columns.
+	Section string
+	// Service is the MapServer URL. Defaults to LayerService from the config.
+	Service string
+	// ID is the layer index within the MapServer.
+	ID int
+	// Name is the layer name as published, used to match discovered layers.
+	Name string
+	// Title is the section heading shown in the report.
+	Title string
+	// Columns are the attribute fields shown for layers without a registered section.
+	Columns []string
+	// Labels are the column headings for Columns. Field names are used when empty.
+	Labels []string
+}
+
+// Query returns the section query for the layer.
+func (l CatalogLayer) Query() SectionQuery {
+	return SectionQuery{Service: l.Service, LayerID: l.ID, OutFields: "*"}
+}
+
+// URL is the REST endpoint of the layer.
+func (l CatalogLayer) URL() string {
+	return strings.TrimRight(l.Service, "/") + "/" + strconv.Itoa(l.ID)
+}
+
+// Label returns the heading for column i.
+func (l CatalogLayer) Label(i int) string {
+	if i < len(l.Labels) && l.Labels[i] != "" {
+		return l.Labels[i]
+	}
+	return l.Columns[i]
+}
+
+// layerService and layerCatalog are set from the config in main.
+var layerService = defaultLayerService
+var layerCatalog []CatalogLayer
+
+// defaultCatalog is the layer list the report was originally built around.
+func defaultCatalog() []CatalogLayer {
+	return []CatalogLayer{
+		{Section: "FireStations", ID: 0, Name: "FireStations", Title: "Fire stations"},
+		{Section: "CommunitiesAtRisk", ID: 1, Name: "CommunitiesAtRisk", Title: "Communities At Risk"},
+		{Section: "IncorporatedCityBoundaries", ID: 2, Name: "IncorporatedCityBoundaries", Title: "Incorporated City Boundaries"},
+		{Section: "VegetationTreatments", ID: 3, Name: "VegetationTreatments", Title: "Vegetation Treatments"},
+		{Section: "WatershedsHUC8", ID: 4, Name: "WatershedsHUC8", Title: "Watersheds HUC8"},
+		{Section: "County", ID: 5, Name: "County", Title: "Counties with intersecting data"},
+	}
+}
+
+// LoadCatalog builds the layer catalog from the config. With DiscoverLayers set
+// the layers are read from the MapServer and the configured layers override
+// the matching discovered ones, found by Name or else by URL. Configured
+// layers the MapServer doesn't list are kept at the end, and logged. If discovery fails the configured (or
+// default) catalog is used instead.
+func LoadCatalog(config Config) []CatalogLayer {
+	if config.LayerService != "" {
+		layerService = config.LayerService
+	}
+	configured := config.Layers
+	if len(configured) == 0 {
+		configured = defaultCatalog()
+	}
+	for i := range configured {
+		if configured[i].Service == "" {
+			configured[i].Service = layerService
+		}
+	}
+	if !config.DiscoverLayers {
+		return configured
+	}
+	discovered, err := DiscoverCatalog(layerService)
+	if err != nil {
+		log.Println("Layer discovery failed, using configured layers: ", err)
+		return configured
+	}
+	for _, c := range configured {
+		i := matchLayer(discovered, c)
+		if i < 0 {
+			log.Println("Layer " + c.Name + " was not discovered in " + layerService + ", using " + c.URL())
+			discovered = append(discovered, c)
+			continue
+		}
+		discovered[i] = mergeLayer(discovered[i], c)
+	}
+	return discovered
+}
+
+// matchLayer finds the discovered layer a configured one overrides: the one
+// with the same Name or, failing that, the same URL. It is -1 when there is
+// none.
+func matchLayer(discovered []CatalogLayer, c CatalogLayer) int {
+	if c.Name != "" {
+		for i, layer := range discovered {
+			if sectionKey(c.Name) == sectionKey(layer.Name) {
+				return i
+			}
+		}
+	}
+	for i, layer := range discovered {
+		if c.URL() == layer.URL() {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeLayer overlays the non-empty settings of a configured layer onto a discovered one.
+func mergeLayer(layer CatalogLayer, c CatalogLayer) CatalogLayer {
+	if c.Section != "" {
+		layer.Section = c.Section
+	}
+	if c.Title != "" {
+		layer.Title = c.Title
+	}
+	if len(c.Columns) > 0 {
+		layer.Columns = c.Columns
+		layer.Labels = c.Labels
+	}
+	return layer
+}
+
+// mapServerInfo is the part of MapServer?f=json that discovery uses.
+type mapServerInfo struct {
+	Layers []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"layers"`
+}
+
+// layerInfo is the part of MapServer/{id}?f=json that discovery and the
+// provenance appendix use. LastEditDate is in milliseconds since 1970.
+type layerInfo struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Fields []struct {
+		Name  string `json:"name"`
+		Type  string `json:"type"`
+		Alias string `json:"alias"`
+	} `json:"fields"`
+	EditingInfo struct {
+		LastEditDate int64 `json:"lastEditDate"`
+	} `json:"editingInfo"`
+}
+
+// hiddenFieldTypes are field types that never make sense in a report table.
+var hiddenFieldTypes = []string{"esriFieldTypeOID", "esriFieldTypeGeometry", "esriFieldTypeGlobalID", "esriFieldTypeBlob", "esriFieldTypeRaster"}
+
+// DiscoverCatalog reads the layers and their fields from a MapServer.
+func DiscoverCatalog(service string) ([]CatalogLayer, error) {
+	var netClient = &http.Client{
+		Timeout: time.Second * 10,
+	}
+	var server mapServerInfo
+	if err := getJSON(context.Background(), netClient, strings.TrimRight(service, "/")+"?f=json", &server); err != nil {
+		return nil, err
+	}
+	if len(server.Layers) == 0 {
+		return nil, errors.New("MapServer " + service + " has no layers")
+	}
+	var catalog []CatalogLayer
+	for _, l := range server.Layers {
+		layer := CatalogLayer{Service: service, ID: l.ID, Name: l.Name, Title: l.Name}
+		var info layerInfo
+		if err := getJSON(context.Background(), netClient, layer.URL()+"?f=json", &info); err != nil {
+			return nil, err
+		}
+		if info.Type != "Feature Layer" {
+			continue
+		}
+		for _, field := range info.Fields {
+			if stringInSlice(field.Type, hiddenFieldTypes) || strings.HasPrefix(field.Name, "Shape") {
+				continue
+			}
+			layer.Columns = append(layer.Columns, field.Name)
+			layer.Labels = append(layer.Labels, field.Alias)
+		}
+		for _, s := range sectionRegistry {
+			if sectionKey(s.Name) == sectionKey(l.Name) {
+				layer.Section = s.Name
+			}
+		}
+		catalog = append(catalog, layer)
+	}
+	return catalog, nil
+}
+
+// sectionKey normalises layer and section names so "Fire Stations" matches "FireStations".
+func sectionKey(name string) string {
+	return strings.ToLower(strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' {
+			return -1
+		}
+		return r
+	}, name))
+}
+
+// getJSON fetches url and decodes the JSON response into v. The request is
+// abandoned when ctx is cancelled.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeMapServer lists FireStations as layer 0, Fire Perimeters as layer 1 and
+// a raster as layer 2.
+func fakeMapServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `{"layers":[{"id":0,"name":"FireStations"},{"id":1,"name":"Fire Perimeters"},{"id":2,"name":"Elevation"}]}`)
+		case "/0":
+			fmt.Fprint(w, `{"name":"FireStations","type":"Feature Layer","fields":[{"name":"OBJECTID","type":"esriFieldTypeOID"},{"name":"INSTNAME","type":"esriFieldTypeString","alias":"Station"}]}`)
+		case "/1":
+			fmt.Fprint(w, `{"name":"Fire Perimeters","type":"Feature Layer","fields":[{"name":"FIRENAME","type":"esriFieldTypeString","alias":"Fire"},{"name":"Shape_Area","type":"esriFieldTypeDouble"}]}`)
+		case "/2":
+			fmt.Fprint(w, `{"name":"Elevation","type":"Raster Layer"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestLoadCatalogDiscovery(t *testing.T) {
+	defer func(service string) { layerService = service }(layerService)
+	server := fakeMapServer()
+	defer server.Close()
+	tests := []struct {
+		name       string
+		configured []CatalogLayer
+		want       []string // titles, in catalog order
+	}{
+		{"matched by name", []CatalogLayer{{Name: "Fire Perimeters", ID: 7, Title: "Past fires"}}, []string{"FireStations", "Past fires"}},
+		{"matched by URL", []CatalogLayer{{ID: 1, Title: "Past fires"}}, []string{"FireStations", "Past fires"}},
+		{"unknown name matched by URL", []CatalogLayer{{Name: "Perimeters", ID: 1, Title: "Past fires"}}, []string{"FireStations", "Past fires"}},
+		{"name wins over URL", []CatalogLayer{{Name: "FireStations", ID: 1, Title: "Stations"}}, []string{"Stations", "Fire Perimeters"}},
+		{"not discovered", []CatalogLayer{{Name: "Hospitals", ID: 6, Title: "Hospitals"}}, []string{"FireStations", "Fire Perimeters", "Hospitals"}},
+	}
+	for _, test := range tests {
+		catalog := LoadCatalog(Config{LayerService: server.URL, DiscoverLayers: true, Layers: test.configured})
+		var titles []string
+		for _, layer := range catalog {
+			titles = append(titles, layer.Title)
+		}
+		if fmt.Sprint(titles) != fmt.Sprint(test.want) {
+			t.Errorf("%s: catalog %q, want %q", test.name, titles, test.want)
+		}
+	}
+	catalog := LoadCatalog(Config{LayerService: server.URL, DiscoverLayers: true, Layers: []CatalogLayer{{Name: "Fire Perimeters", ID: 7}}})
+	if perimeters := catalog[1]; perimeters.ID != 1 || fmt.Sprint(perimeters.Columns) != "[FIRENAME]" || fmt.Sprint(perimeters.Labels) != "[Fire]" {
+		t.Errorf("Fire Perimeters discovered as ID %d with %v labelled %v", perimeters.ID, perimeters.Columns, perimeters.Labels)
+	}
+	if catalog[0].Section != "FireStations" {
+		t.Errorf("FireStations discovered with section %q", catalog[0].Section)
+	}
+}
+
+func TestLoadCatalogDiscoveryFails(t *testing.T) {
+	defer func(service string) { layerService = service }(layerService)
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	catalog := LoadCatalog(Config{LayerService: server.URL, DiscoverLayers: true})
+	if len(catalog) != len(defaultCatalog()) || catalog[0].Service != server.URL {
+		t.Errorf("catalog %v, want the default layers on %s", catalog, server.URL)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"os"
 
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -88,6 +87,29 @@ func main() {
 		dbpass = configf.DBPass
 		dbname = configf.DBName
 		secret = configf.Secret
+		layerCatalog = LoadCatalog(configf) //this is in layers.go
+		if configf.QueryWorkers > 0 {
+			queryWorkers = configf.QueryWorkers
+		}
+		if configf.LayerTimeout > 0 {
+			layerTimeout = time.Duration(configf.LayerTimeout) * time.Second
+		}
+		if configf.MaxFeatures > 0 {
+			maxLayerFeatures = configf.MaxFeatures
+		}
+		mapFeatures = configf.MapFeatures //this is in map.go
+		reportTheme = LoadTheme(configf.Theme) //this is in theme.go
+		riskWeights = LoadRiskWeights(configf.RiskScore) //this is in summary.go
+		stationSearch = LoadStationSearch(configf.Stations) //this is in stations.go
+		if configf.BatchFeatures > 0 {
+			batchMaxFeatures = configf.BatchFeatures //this is in batch.go
+		}
+		if configf.BatchJobs > 0 {
+			batchMaxJobs = configf.BatchJobs
+		}
+		if configf.NarrativeDir != "" {
+			narrativeDir = configf.NarrativeDir
+		}
 		mySigningKey = []byte(secret)
 		token = jwt.New(jwt.SigningMethodHS256)
 		claims = token.Claims.(jwt.MapClaims)
@@ -164,9 +186,10 @@ func cleanup(window string) {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	forgetBatchJobs(cutoff) //this is in batch.go
 	now := time.Now()
 	for _, info := range fileInfo {
-		if strings.HasSuffix(info.Name(), "pdf") {
+		if isReportFile(info.Name()) {
 			if diff := now.Sub(info.ModTime()); diff > cutoff {
 				fmt.Printf("Deleting %s which is %s old\n", info.Name(), diff)
 				var err = os.Remove("/tmp/" + info.Name())
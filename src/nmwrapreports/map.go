@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/wcharczuk/go-chart"
+	"github.com/wcharczuk/go-chart/drawing"
+)
+
+// mapFeatures says whether the fire station, community and treatment features
+// are drawn on the locator map as well as the county outlines. Turning it on
+// makes those layer queries return geometry.
+var mapFeatures bool
+
+// mapPixels is the width and height of the rendered locator map.
+const mapPixels = 1600
+
+// mapSize is the width and height of the locator map on the PDF page, in mm.
+const mapSize = 180.0
+
+var (
+	mapBackground = drawing.Color{R: 250, G: 248, B: 242, A: 255}
+	mapGraticule  = drawing.Color{R: 190, G: 190, B: 190, A: 255}
+	mapInk        = drawing.Color{R: 40, G: 40, B: 40, A: 255}
+	mapAOIStroke  = drawing.Color{R: 199, G: 125, B: 26, A: 255}
+	mapAOIFill    = drawing.Color{R: 199, G: 125, B: 26, A: 60}
+)
+
+// MapStyle is how a section's features are drawn on the locator map. Points
+// are drawn as circles of Radius pixels. Context layers, such as the county
+// outlines, are drawn underneath the others.
+type MapStyle struct {
+	Stroke  drawing.Color
+	Fill    drawing.Color
+	Width   float64
+	Radius  float64
+	Context bool
+}
+
+// mappedSection is a ReportSection whose features are drawn on the locator
+// map. Its query has to return geometry for anything to be drawn.
+type mappedSection interface {
+	MapStyle() MapStyle
+}
+
+// MapLayer is the geometry of one section and the style to draw it in.
+type MapLayer struct {
+	Style      MapStyle
+	Geometries []*EsriGeometry
+}
+
+// mapLayers collects the geometry of every mapped section whose layer came
+// back. Only context layers are drawn unless mapFeatures is set. Context
+// layers come first and the rest follow in report order, so
+// later sections are drawn on top.
+func mapLayers(sections []ReportSection, results []layerResult) []MapLayer {
+	var context, layers []MapLayer
+	for i, section := range sections {
+		mapped, ok := section.(mappedSection)
+		if !ok || results[i].Status != LayerOK || !(mapFeatures || mapped.MapStyle().Context) {
+			continue
+		}
+		var features esriFeatureSet
+		if err := json.Unmarshal(results[i].Body, &features); err != nil {
+			continue
+		}
+		layer := MapLayer{Style: mapped.MapStyle()}
+		for _, feature := range features.Features {
+			if feature.Geometry != nil {
+				layer.Geometries = append(layer.Geometries, feature.Geometry)
+			}
+		}
+		if layer.Style.Context {
+			context = append(context, layer)
+		} else {
+			layers = append(layers, layer)
+		}
+	}
+	return append(context, layers...)
+}
+
+// mapExtent is the Web Mercator area shown on the map and the scale it is drawn at.
+type mapExtent struct {
+	MinX, MaxY float64
+	Scale      float64 // metres per pixel
+}
+
+// newMapExtent fits the area of interest into a square map with some margin
+// around it. Only the counties the area intersects are drawn, not the ones
+// around them; the margin shows more of their outlines.
+func newMapExtent(rings [][][]float64) mapExtent {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, ring := range rings {
+		for _, pt := range ring {
+			minX, maxX = math.Min(minX, pt[0]), math.Max(maxX, pt[0])
+			minY, maxY = math.Min(minY, pt[1]), math.Max(maxY, pt[1])
+		}
+	}
+	//Never zoom in further than about 2km across, so a tiny area still has a useful map.
+	size := math.Max(math.Max(maxX-minX, maxY-minY)*1.6, 2000)
+	centerX, centerY := (minX+maxX)/2, (minY+maxY)/2
+	return mapExtent{MinX: centerX - size/2, MaxY: centerY + size/2, Scale: size / mapPixels}
+}
+
+// pixel converts a Web Mercator point to map pixel coordinates.
+func (e mapExtent) pixel(x, y float64) (int, int) {
+	return int(math.Round((x - e.MinX) / e.Scale)), int(math.Round((e.MaxY - y) / e.Scale))
+}
+
+// lonLat is the longitude/latitude of a map pixel.
+func (e mapExtent) lonLat(px, py float64) (lon, lat float64) {
+	return mercatorToLonLat(e.MinX+px*e.Scale, e.MaxY-py*e.Scale)
+}
+
+// locatorMap renders the area of interest over the given layers as a map
+// with a graticule, scale bar and north arrow. ok is false when there is no
+// area to draw or the map could not be rendered.
+func locatorMap(aoi Geom, layers []MapLayer) (content SectionContent, ok bool) {
+	if len(aoi.Rings) == 0 {
+		return content, false
+	}
+	extent := newMapExtent(aoi.Rings)
+	r, err := chart.PNG(mapPixels, mapPixels)
+	if err != nil {
+		return content, false
+	}
+	if font := reportTheme.chartFont(); font != nil {
+		r.SetFont(font)
+	} else if font, err := chart.GetDefaultFont(); err == nil {
+		r.SetFont(font)
+	}
+
+	r.SetFillColor(mapBackground)
+	r.MoveTo(0, 0)
+	r.LineTo(mapPixels, 0)
+	r.LineTo(mapPixels, mapPixels)
+	r.LineTo(0, mapPixels)
+	r.Close()
+	r.Fill()
+
+	drawGraticule(r, extent)
+	for _, layer := range layers {
+		for _, geometry := range layer.Geometries {
+			drawGeometry(r, extent, geometry, layer.Style)
+		}
+	}
+	drawGeometry(r, extent, &EsriGeometry{Rings: aoi.Rings}, MapStyle{Stroke: mapAOIStroke, Fill: mapAOIFill, Width: 6})
+	drawScaleBar(r, extent)
+	drawNorthArrow(r)
+
+	buffer := bytes.NewBuffer([]byte{})
+	if err := r.Save(buffer); err != nil {
+		return content, false
+	}
+	blurb := "The area of interest is outlined in orange. County boundaries are shown in grey."
+	if mapFeatures {
+		blurb += " Fire stations in and around the area are shown in red, communities at risk in purple and vegetation treatments in green."
+	}
+	return SectionContent{
+		Title:  "Location",
+		Blurb:  blurb,
+		Charts: []ContentChart{{Name: "locatormap", PNG: buffer.Bytes(), Width: mapSize, Height: mapSize}},
+	}, true
+}
+
+// drawGeometry draws one feature. Polygons are filled and outlined, lines are
+// stroked and points are drawn as circles.
+func drawGeometry(r chart.Renderer, extent mapExtent, geometry *EsriGeometry, style MapStyle) {
+	r.SetStrokeColor(style.Stroke)
+	r.SetFillColor(style.Fill)
+	r.SetStrokeWidth(style.Width)
+	switch {
+	case geometry.X != nil && geometry.Y != nil:
+		x, y := extent.pixel(*geometry.X, *geometry.Y)
+		r.Circle(style.Radius, x, y)
+		r.FillStroke()
+	case len(geometry.Points) > 0:
+		for _, pt := range geometry.Points {
+			x, y := extent.pixel(pt[0], pt[1])
+			r.Circle(style.Radius, x, y)
+			r.FillStroke()
+		}
+	case len(geometry.Paths) > 0:
+		for _, path := range geometry.Paths {
+			drawPath(r, extent, path, false)
+		}
+		r.Stroke()
+	case len(geometry.Rings) > 0:
+		for _, ring := range geometry.Rings {
+			drawPath(r, extent, ring, true)
+		}
+		r.FillStroke()
+	}
+}
+
+// drawPath adds a path or ring to the renderer's current path.
+func drawPath(r chart.Renderer, extent mapExtent, path [][]float64, closed bool) {
+	for i, pt := range path {
+		x, y := extent.pixel(pt[0], pt[1])
+		if i == 0 {
+			r.MoveTo(x, y)
+		} else {
+			r.LineTo(x, y)
+		}
+	}
+	if closed {
+		r.Close()
+	}
+}
+
+// graticuleSteps are the spacings, in degrees, the graticule can be drawn at.
+var graticuleSteps = []float64{0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.25, 0.5, 1, 2, 5}
+
+// drawGraticule draws lines of longitude and latitude with their values
+// along the top and left edges of the map.
+func drawGraticule(r chart.Renderer, extent mapExtent) {
+	west, north := extent.lonLat(0, 0)
+	east, south := extent.lonLat(mapPixels, mapPixels)
+	step := graticuleSteps[len(graticuleSteps)-1]
+	for _, s := range graticuleSteps {
+		if (east-west)/s <= 6 {
+			step = s
+			break
+		}
+	}
+	decimals := 0
+	if i := strings.Index(strconv.FormatFloat(step, 'f', -1, 64), "."); i >= 0 {
+		decimals = len(strconv.FormatFloat(step, 'f', -1, 64)) - i - 1
+	}
+
+	r.SetStrokeColor(mapGraticule)
+	r.SetStrokeWidth(2)
+	r.SetFontColor(mapInk)
+	r.SetFontSize(18)
+	for lon := math.Ceil(west/step) * step; lon < east; lon += step {
+		x, _ := extent.pixel(lonLatToMercator(lon, 0))
+		r.MoveTo(x, 0)
+		r.LineTo(x, mapPixels)
+		r.Stroke()
+		r.Text(degrees(lon, decimals, "E", "W"), x+6, 28)
+	}
+	for lat := math.Ceil(south/step) * step; lat < north; lat += step {
+		_, y := extent.pixel(lonLatToMercator(0, lat))
+		r.MoveTo(0, y)
+		r.LineTo(mapPixels, y)
+		r.Stroke()
+		r.Text(degrees(lat, decimals, "N", "S"), 8, y-8)
+	}
+}
+
+// degrees formats a longitude or latitude for a graticule label.
+func degrees(value float64, decimals int, positive string, negative string) string {
+	hemisphere := positive
+	if value < 0 {
+		hemisphere = negative
+	}
+	return strconv.FormatFloat(math.Abs(value), 'f', decimals, 64) + "°" + hemisphere
+}
+
+// drawScaleBar draws a bar of a round distance in the bottom left corner.
+// Web Mercator stretches distances away from the equator, so the ground
+// distance per pixel is taken at the middle of the map.
+func drawScaleBar(r chart.Renderer, extent mapExtent) {
+	_, lat := extent.lonLat(mapPixels/2, mapPixels/2)
+	metresPerPixel := extent.Scale * math.Cos(lat*math.Pi/180)
+	distance := niceDistance(metresPerPixel * mapPixels / 4)
+	length := int(distance / metresPerPixel)
+	label := strconv.FormatFloat(distance, 'f', -1, 64) + " m"
+	if distance >= 1000 {
+		label = strconv.FormatFloat(distance/1000, 'f', -1, 64) + " km"
+	}
+	label += " (" + strconv.FormatFloat(distance/1609.344, 'f', 2, 64) + " mi)"
+
+	left, bottom := 60, mapPixels-60
+	r.SetFillColor(drawing.ColorWhite)
+	r.SetStrokeColor(mapInk)
+	r.SetStrokeWidth(3)
+	r.MoveTo(left, bottom-20)
+	r.LineTo(left+length, bottom-20)
+	r.LineTo(left+length, bottom)
+	r.LineTo(left, bottom)
+	r.Close()
+	r.FillStroke()
+	r.SetFillColor(mapInk)
+	r.MoveTo(left, bottom-20)
+	r.LineTo(left+length/2, bottom-20)
+	r.LineTo(left+length/2, bottom)
+	r.LineTo(left, bottom)
+	r.Close()
+	r.Fill()
+	r.SetFontColor(mapInk)
+	r.SetFontSize(22)
+	r.Text(label, left, bottom-34)
+}
+
+// niceDistance rounds metres down to 1, 2 or 5 times a power of ten.
+func niceDistance(metres float64) float64 {
+	magnitude := math.Pow(10, math.Floor(math.Log10(metres)))
+	for _, step := range []float64{5, 2, 1} {
+		if step*magnitude <= metres {
+			return step * magnitude
+		}
+	}
+	return magnitude
+}
+
+// drawNorthArrow draws an arrow pointing up in the top right corner. Web
+// Mercator keeps north straight up everywhere on the map.
+func drawNorthArrow(r chart.Renderer) {
+	x, y := mapPixels-90, 70
+	r.SetStrokeColor(mapInk)
+	r.SetStrokeWidth(3)
+	r.SetFillColor(mapInk)
+	r.MoveTo(x, y)
+	r.LineTo(x+30, y+90)
+	r.LineTo(x, y+70)
+	r.Close()
+	r.FillStroke()
+	r.SetFillColor(drawing.ColorWhite)
+	r.MoveTo(x, y)
+	r.LineTo(x-30, y+90)
+	r.LineTo(x, y+70)
+	r.Close()
+	r.FillStroke()
+	r.SetFontColor(mapInk)
+	r.SetFontSize(30)
+	r.Text("N", x-11, y+130)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// areaUnit is a unit areas can be reported in, with the unit perimeters and
+// other lengths go with it.
+type areaUnit struct {
+	Label    string // "acres", after a number
+	Header   string // "Acres", in a table heading
+	Metres   float64
+	Decimals int
+	Length   lengthUnit
+}
+
+// lengthUnit is a unit lengths can be reported in.
+type lengthUnit struct {
+	Label  string
+	Metres float64
+}
+
+var (
+	miles      = lengthUnit{"mi", 1609.344}
+	kilometres = lengthUnit{"km", 1000}
+)
+
+// areaUnits are the values of the units report option.
+var areaUnits = map[string]areaUnit{
+	"acres":    {"acres", "Acres", acreMetres, 1, miles},
+	"hectares": {"ha", "Hectares", 10000, 1, kilometres},
+	"sqmi":     {"sq mi", "Sq miles", 2589988.110336, 2, miles},
+}
+
+// areaUnitOrder is the order the units are listed in when all are shown.
+var areaUnitOrder = []string{"acres", "hectares", "sqmi"}
+
+// area formats square metres in the unit.
+func (u areaUnit) area(metres float64) string {
+	return formatNumber(metres/u.Metres, u.Decimals) + " " + u.Label
+}
+
+// fromAcres converts acres, as some layers record areas, to the unit.
+func (u areaUnit) fromAcres(acres float64) float64 {
+	return acres * acreMetres / u.Metres
+}
+
+// length formats metres in the unit's length unit.
+func (u areaUnit) length(metres float64) string {
+	return formatNumber(metres/u.Length.Metres, 2) + " " + u.Length.Label
+}
+
+// GeometrySummary is the size and position of a Web Mercator polygon on the
+// ground: areas and lengths in metres, positions in degrees.
+type GeometrySummary struct {
+	Area        float64
+	Perimeter   float64
+	CentroidLon float64
+	CentroidLat float64
+	West        float64
+	South       float64
+	East        float64
+	North       float64
+}
+
+// summarizeGeometry measures polygon rings. Holes take away from the area and
+// add to the perimeter.
+func summarizeGeometry(rings [][][]float64) GeometrySummary {
+	summary := GeometrySummary{
+		Area:  geodesicArea(rings),
+		West:  math.Inf(1),
+		South: math.Inf(1),
+		East:  math.Inf(-1),
+		North: math.Inf(-1),
+	}
+	for _, ring := range ringsToLonLat(rings) {
+		summary.Perimeter += ringLength(ring)
+		for _, pt := range ring {
+			summary.West = math.Min(summary.West, pt[0])
+			summary.East = math.Max(summary.East, pt[0])
+			summary.South = math.Min(summary.South, pt[1])
+			summary.North = math.Max(summary.North, pt[1])
+		}
+	}
+	if math.IsInf(summary.West, 1) {
+		return GeometrySummary{}
+	}
+	summary.CentroidLon, summary.CentroidLat = centroid(rings, (summary.South+summary.North)/2)
+	return summary
+}
+
+// ringLength is the length on the sphere, in metres, of a longitude/latitude
+// ring, closing it if it isn't already.
+func ringLength(ring [][]float64) float64 {
+	length := 0.0
+	for i := range ring {
+		length += greatCircle(ring[i], ring[(i+1)%len(ring)])
+	}
+	return length
+}
+
+// greatCircle is the haversine distance in metres between two
+// longitude/latitude points.
+func greatCircle(a, b []float64) float64 {
+	lat1, lat2 := a[1]*math.Pi/180, b[1]*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b[0] - a[0]) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(math.Sqrt(h), 1))
+}
+
+// centroid is the centre of area of Web Mercator polygon rings, in degrees.
+// It is worked out on a plate carrée squeezed to be true to scale at the
+// latitude lat0, which is close enough to equal area for anything the size
+// of a state. Falls back to the middle of the rings' points when they have
+// no area.
+func centroid(rings [][][]float64, lat0 float64) (lon, lat float64) {
+	squeeze := math.Cos(lat0 * math.Pi / 180)
+	sumX, sumY, sumArea := 0.0, 0.0, 0.0
+	points, midX, midY := 0.0, 0.0, 0.0
+	for _, polygon := range polygonsFromRings(rings) {
+		for i, ring := range ringsToLonLat(polygon) {
+			sign := 1.0
+			if i > 0 {
+				sign = -1
+			}
+			area, cx, cy := 0.0, 0.0, 0.0
+			for j := range ring {
+				x0, y0 := ring[j][0]*squeeze, ring[j][1]
+				x1, y1 := ring[(j+1)%len(ring)][0]*squeeze, ring[(j+1)%len(ring)][1]
+				cross := x0*y1 - x1*y0
+				area += cross
+				cx += (x0 + x1) * cross
+				cy += (y0 + y1) * cross
+				points++
+				midX += x0
+				midY += y0
+			}
+			if area == 0 {
+				continue
+			}
+			//cx/3area is the ring's centroid whichever way it winds; weight it by its unsigned area.
+			weight := sign * math.Abs(area/2)
+			sumX += cx / (3 * area) * weight
+			sumY += cy / (3 * area) * weight
+			sumArea += weight
+		}
+	}
+	if sumArea <= 0 {
+		if points == 0 {
+			return 0, 0
+		}
+		return midX / points / squeeze, midY / points
+	}
+	return sumX / sumArea / squeeze, sumY / sumArea
+}
+
+// degreesText formats a latitude or longitude with its hemisphere, such as
+// 35.08412° N.
+func degreesText(v float64, positive string, negative string) string {
+	hemisphere := positive
+	if v < 0 {
+		hemisphere = negative
+	}
+	return strconv.FormatFloat(math.Abs(v), 'f', 5, 64) + "° " + hemisphere
+}
+
+// latLonText formats a point as latitude, longitude.
+func latLonText(lon, lat float64) string {
+	return degreesText(lat, "N", "S") + ", " + degreesText(lon, "E", "W")
+}
+
+// boxText formats a bounding box as its latitude and longitude ranges, such
+// as 34.91 to 35.22° N, 106.81 to 106.10° W.
+func boxText(summary GeometrySummary) string {
+	span := func(from, to float64, positive string, negative string) string {
+		hemisphere := positive
+		if from < 0 && to < 0 {
+			hemisphere = negative
+			from, to = -from, -to
+		}
+		return strconv.FormatFloat(from, 'f', 2, 64) + " to " + strconv.FormatFloat(to, 'f', 2, 64) + "° " + hemisphere
+	}
+	return span(summary.South, summary.North, "N", "S") + ", " + span(summary.West, summary.East, "E", "W")
+}
+
+// featureGeometryTable lists the ground area, perimeter, centroid and
+// bounding box of each polygon feature of a section, from its overlaps.
+// names are the feature names in feature order. ok is false when no feature
+// was measured.
+func featureGeometryTable(names []string, overlaps []Overlap, units areaUnit) (table ContentTable, ok bool) {
+	for i, name := range names {
+		if i >= len(overlaps) || overlaps[i].Feature.Area == 0 {
+			continue
+		}
+		feature := overlaps[i].Feature
+		table.Rows = append(table.Rows, []string{
+			name,
+			units.area(feature.Area),
+			units.length(feature.Perimeter),
+			latLonText(feature.CentroidLon, feature.CentroidLat),
+			boxText(feature),
+		})
+	}
+	if len(table.Rows) == 0 {
+		return table, false
+	}
+	table.Caption = "Size and position of each feature, measured on the ground"
+	table.Widths = []float64{34, 24, 22, 50, 60}
+	table.Headers = []string{"Name", "Area", "Perimeter", "Centroid", "Bounding box"}
+	table.Align = []string{"L", "R", "R", "L", "L"}
+	return table, true
+}
+
+// geometrySummary is a section describing the size and position of the area
+// of interest, with the area in every unit, the requested one first. ok is
+// false when there is no area.
+func geometrySummary(aoi Geom, units areaUnit) (content SectionContent, ok bool) {
+	if len(aoi.Rings) == 0 {
+		return content, false
+	}
+	summary := summarizeGeometry(aoi.Rings)
+	rows := [][]string{{"Area", units.area(summary.Area)}}
+	for _, name := range areaUnitOrder {
+		if other := areaUnits[name]; other != units {
+			rows = append(rows, []string{"", other.area(summary.Area)})
+		}
+	}
+	rows = append(rows,
+		[]string{"Perimeter", units.length(summary.Perimeter)},
+		[]string{"Centroid", latLonText(summary.CentroidLon, summary.CentroidLat)},
+		[]string{"North-west corner", latLonText(summary.West, summary.North)},
+		[]string{"South-east corner", latLonText(summary.East, summary.South)},
+	)
+	content = SectionContent{
+		Title: "Area of interest",
+		Blurb: "Areas and lengths are measured on the ground, not on the Web Mercator map the area was drawn on, which makes areas in New Mexico look about half as large again as they are. " +
+			"The centroid and the corners of the bounding box are WGS 84 latitude and longitude.",
+		Tables: []ContentTable{{
+			Widths:  []float64{60, 130},
+			Headers: []string{"Measure", "Value"},
+			Rows:    rows,
+		}},
+	}
+	return content, true
+}
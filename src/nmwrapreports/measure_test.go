@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPolygonsFromRings(t *testing.T) {
+	outer := box(-106, 35, -105, 36)
+	hole := reverseRing(box(-105.75, 35.25, -105.25, 35.75))
+	other := box(-104, 32, -103, 33)
+	tests := []struct {
+		name  string
+		rings [][][]float64
+		want  []int // rings in each polygon
+	}{
+		{"one polygon", [][][]float64{outer}, []int{1}},
+		{"hole before its outer ring", [][][]float64{hole, outer}, []int{2}},
+		{"hole goes with the ring around it", [][][]float64{other, outer, hole}, []int{1, 2}},
+		{"lone counter-clockwise ring", [][][]float64{reverseRing(other)}, []int{1}},
+	}
+	for _, test := range tests {
+		polygons := polygonsFromRings(test.rings)
+		if len(polygons) != len(test.want) {
+			t.Errorf("%s: %d polygons, want %d", test.name, len(polygons), len(test.want))
+			continue
+		}
+		for i, polygon := range polygons {
+			if len(polygon) != test.want[i] {
+				t.Errorf("%s: polygon %d has %d rings, want %d", test.name, i, len(polygon), test.want[i])
+			}
+			if ringArea(polygon[0]) > 0 {
+				t.Errorf("%s: polygon %d outer ring is not clockwise", test.name, i)
+			}
+		}
+	}
+}
+
+func TestSummarizeGeometry(t *testing.T) {
+	tests := []struct {
+		name     string
+		rings    [][][]float64
+		lon, lat float64
+	}{
+		{"box", [][][]float64{box(-106.5, 34.5, -105.5, 35.5)}, -106, 35},
+		{"box with a centred hole", [][][]float64{box(-106.5, 34.5, -105.5, 35.5), reverseRing(box(-106.1, 34.9, -105.9, 35.1))}, -106, 35},
+		{"two equal boxes", [][][]float64{box(-107, 35, -106, 36), box(-105, 35, -104, 36)}, -105.5, 35.5},
+	}
+	for _, test := range tests {
+		summary := summarizeGeometry(test.rings)
+		//The centroid is worked out on a plate carrée, so it sits a little off the middle in latitude.
+		if math.Abs(summary.CentroidLon-test.lon) > 1e-6 || math.Abs(summary.CentroidLat-test.lat) > 0.01 {
+			t.Errorf("%s: centroid %f, %f, want %f, %f", test.name, summary.CentroidLon, summary.CentroidLat, test.lon, test.lat)
+		}
+	}
+	summary := summarizeGeometry([][][]float64{box(-106, 35, -105, 36)})
+	if !closeTo(summary.West, -106, 1e-9) || !closeTo(summary.North, 36, 1e-9) || !closeTo(summary.East, -105, 1e-9) || !closeTo(summary.South, 35, 1e-9) {
+		t.Errorf("box: bounds %f %f %f %f", summary.West, summary.South, summary.East, summary.North)
+	}
+	//Two meridians a degree of latitude long and two parallels a degree of longitude wide.
+	perimeter := 2*earthRadius*math.Pi/180 + greatCircle([]float64{-106, 35}, []float64{-105, 35}) + greatCircle([]float64{-106, 36}, []float64{-105, 36})
+	if !closeTo(summary.Perimeter, perimeter, 1e-9) {
+		t.Errorf("box: perimeter %f, want %f", summary.Perimeter, perimeter)
+	}
+}
+
+func TestBoxText(t *testing.T) {
+	tests := []struct {
+		summary GeometrySummary
+		want    string
+	}{
+		{GeometrySummary{West: -106.81, South: 34.91, East: -106.1, North: 35.22}, "34.91 to 35.22° N, 106.81 to 106.10° W"},
+		{GeometrySummary{West: 150, South: -35, East: 151, North: -34}, "35.00 to 34.00° S, 150.00 to 151.00° E"},
+	}
+	for _, test := range tests {
+		if got := boxText(test.summary); got != test.want {
+			t.Errorf("boxText = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestFeatureGeometryTable(t *testing.T) {
+	square := summarizeGeometry([][][]float64{box(-106, 35, -105, 36)})
+	overlaps := []Overlap{{Feature: square}, {}}
+	table, ok := featureGeometryTable([]string{"Measured", "No geometry", "No overlap"}, overlaps, areaUnits["hectares"])
+	if !ok {
+		t.Fatal("featureGeometryTable found nothing to measure")
+	}
+	if len(table.Rows) != 1 || table.Rows[0][0] != "Measured" {
+		t.Fatalf("rows %v, want only Measured", table.Rows)
+	}
+	if want := areaUnits["hectares"].area(square.Area); table.Rows[0][1] != want {
+		t.Errorf("area %q, want %q", table.Rows[0][1], want)
+	}
+	if _, ok := featureGeometryTable([]string{"No overlap"}, nil, areaUnits["acres"]); ok {
+		t.Error("featureGeometryTable made a table with no overlaps")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// narrativeDir holds the narrative template of each section, named after the
+// registered section: FireStations.tmpl, County.tmpl and so on. Layers
+// without a section of their own use their layer name. They are read for
+// every report, so edits show up in the next report without a restart.
+var narrativeDir = "/var/lib/nmwrapreports/narratives"
+
+// fallbackNarrative is used for layers without a template of their own.
+const fallbackNarrative = `{{.Count}} {{.Title}} features intersect this area.`
+
+// NarrativeData is what a narrative template is executed with. Features is
+// the section's decoded features, so a template can range over them and read
+// their Attributes; the other fields are worked out for it.
+type NarrativeData struct {
+	Title    string
+	Count    int
+	Names    []string
+	Features interface{}
+
+	// Communities at risk by their 2016 risk rating.
+	CARHigh int
+	CARMed  int
+	CARLow  int
+
+	// Prorated says the watershed table gives population and WUI structures
+	// for the part of each watershed inside the area.
+	Prorated bool
+
+	// Acres treated, for vegetation treatments, and the same area in the
+	// report units, such as "1,234.5 ha".
+	Acres float64
+	Area  string
+
+	// Fire stations around the area. Count and Names are the stations inside
+	// it; Nearby counts those within the search Radius and WithinThreshold
+	// those within the response Threshold, both measured from its edge.
+	Nearby          int
+	WithinThreshold int
+	Nearest         string
+	NearestDistance string
+	Radius          string
+	Threshold       string
+}
+
+// narrativeFuncs are the helpers available to narrative templates.
+var narrativeFuncs = template.FuncMap{
+	// plural picks the singular or plural word for n: {{plural .Count "station" "stations"}}
+	"plural": func(n int, one string, many string) string {
+		if n == 1 {
+			return one
+		}
+		return many
+	},
+	// list joins names as "a, b and c".
+	"list": func(names []string) string {
+		if len(names) < 2 {
+			return strings.Join(names, "")
+		}
+		return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+	},
+	// number formats a number with thousands separators and no decimals.
+	"number": func(v float64) string {
+		return formatNumber(v, 0)
+	},
+	// percent is part as a whole percentage of whole.
+	"percent": func(part int, whole int) int {
+		if whole == 0 {
+			return 0
+		}
+		return part * 100 / whole
+	},
+}
+
+// narrative writes the section's blurb from its template in narrativeDir. A
+// missing or broken template is logged and the fallback narrative used, so a
+// typo in a template never stops a report.
+func (s layerSection) narrative(data NarrativeData) string {
+	data.Title = s.layer.Title
+	name := s.layer.Section
+	if name == "" {
+		name = s.layer.Name
+	}
+	path := filepath.Join(narrativeDir, name+".tmpl")
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Reading narrative template: ", err)
+		}
+		text = []byte(fallbackNarrative)
+	}
+	blurb, err := executeNarrative(name, string(text), data)
+	if err != nil {
+		log.Println("Narrative template ", path, ": ", err)
+		blurb, _ = executeNarrative(name, fallbackNarrative, data)
+	}
+	return blurb
+}
+
+// executeNarrative parses and runs one narrative template.
+func executeNarrative(name string, text string, data NarrativeData) (string, error) {
+	tmpl, err := template.New(name).Funcs(narrativeFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// distinct drops empty and repeated names, keeping the first occurrence.
+func distinct(names []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return out
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// overlapStep is the tallest strip, in Web Mercator metres, that
+// overlapArea treats as having a single scale.
+const overlapStep = 1000.0
+
+// Overlap is how much of the area of interest falls inside one polygon feature.
+type Overlap struct {
+	Area         float64 // square metres of the area of interest inside the feature
+	AOIShare     float64 // fraction of the area of interest inside the feature
+	FeatureShare float64 // fraction of the feature inside the area of interest
+	Feature      GeometrySummary
+}
+
+// overlapSection is a ReportSection of polygons that is told how much of the
+// area of interest each of its features covers, in feature order, and the
+// units the report shows areas in. Its query has to return geometry.
+type overlapSection interface {
+	SetOverlaps(overlaps []Overlap, units areaUnit)
+}
+
+// overlapCells are the area and percentage of the area of interest inside
+// feature i, blank when they aren't known.
+func overlapCells(overlaps []Overlap, units areaUnit, i int) (area string, percent string) {
+	if i >= len(overlaps) {
+		return "", ""
+	}
+	return units.area(overlaps[i].Area), strconv.FormatFloat(100*overlaps[i].AOIShare, 'f', 1, 64) + "%"
+}
+
+// prorated is a whole-feature count scaled down to the part of feature i
+// inside the area of interest, blank when that isn't known.
+func prorated(count int, overlaps []Overlap, i int) string {
+	if i >= len(overlaps) {
+		return ""
+	}
+	return formatNumber(float64(count)*overlaps[i].FeatureShare, 0)
+}
+
+// applyOverlaps works out the overlaps for every overlap section whose layer
+// came back. Sections whose features have no geometry get no overlaps.
+func applyOverlaps(aoi Geom, units areaUnit, sections []ReportSection, results []layerResult) {
+	aoiArea := geodesicArea(aoi.Rings)
+	for i, section := range sections {
+		overlapping, ok := section.(overlapSection)
+		if !ok || results[i].Status != LayerOK {
+			continue
+		}
+		var features esriFeatureSet
+		if err := json.Unmarshal(results[i].Body, &features); err != nil || aoiArea == 0 {
+			overlapping.SetOverlaps(nil, units)
+			continue
+		}
+		overlaps := make([]Overlap, len(features.Features))
+		for j, feature := range features.Features {
+			if feature.Geometry == nil || len(feature.Geometry.Rings) == 0 {
+				continue
+			}
+			area := overlapArea(aoi.Rings, feature.Geometry.Rings)
+			overlaps[j] = Overlap{Area: area, AOIShare: math.Min(area/aoiArea, 1), Feature: summarizeGeometry(feature.Geometry.Rings)}
+			if overlaps[j].Feature.Area > 0 {
+				overlaps[j].FeatureShare = math.Min(area/overlaps[j].Feature.Area, 1)
+			}
+		}
+		overlapping.SetOverlaps(overlaps, units)
+	}
+}
+
+// edge is one side of a ring.
+type edge struct {
+	x0, y0, x1, y1 float64
+}
+
+func (e edge) minY() float64 { return math.Min(e.y0, e.y1) }
+func (e edge) maxY() float64 { return math.Max(e.y0, e.y1) }
+
+// ringEdges lists the sides of every ring, leaving out horizontal ones,
+// sorted by their lowest point.
+func ringEdges(rings [][][]float64) []edge {
+	var edges []edge
+	for _, ring := range rings {
+		for i := range ring {
+			j := (i + 1) % len(ring)
+			if ring[i][1] != ring[j][1] {
+				edges = append(edges, edge{ring[i][0], ring[i][1], ring[j][0], ring[j][1]})
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].minY() < edges[j].minY() })
+	return edges
+}
+
+// overlapArea is the area on the sphere, in square metres, of the part of the
+// Web Mercator rings a that lies inside the rings b. Each set of rings is
+// read with the even-odd rule, so holes are handled.
+//
+// The overlap is swept from south to north. Between two consecutive vertices
+// or edge crossings the width of the overlap changes linearly, so its width
+// at the middle of that strip times the strip's height is its exact planar
+// area. Web Mercator stretches area by 1/cos² of the latitude, which is
+// taken out strip by strip, using strips no taller than overlapStep.
+func overlapArea(a, b [][][]float64) float64 {
+	edgesA, edgesB := ringEdges(a), ringEdges(b)
+	if len(edgesA) == 0 || len(edgesB) == 0 {
+		return 0
+	}
+	bottom := math.Max(edgesA[0].minY(), edgesB[0].minY())
+	top := math.Min(highest(edgesA), highest(edgesB))
+	if bottom >= top {
+		return 0
+	}
+
+	ys := []float64{bottom, top}
+	for _, e := range append(append([]edge{}, edgesA...), edgesB...) {
+		for _, y := range []float64{e.y0, e.y1} {
+			if y > bottom && y < top {
+				ys = append(ys, y)
+			}
+		}
+	}
+	for _, ea := range edgesA {
+		for _, eb := range edgesB {
+			if eb.minY() > ea.maxY() {
+				break
+			}
+			if y, ok := crossingY(ea, eb); ok && y > bottom && y < top {
+				ys = append(ys, y)
+			}
+		}
+	}
+	sort.Float64s(ys)
+
+	sweepA, sweepB := newSweep(edgesA), newSweep(edgesB)
+	area := 0.0
+	for i := 0; i+1 < len(ys); i++ {
+		if ys[i+1] <= ys[i] {
+			continue
+		}
+		steps := math.Ceil((ys[i+1] - ys[i]) / overlapStep)
+		height := (ys[i+1] - ys[i]) / steps
+		for k := 0.0; k < steps; k++ {
+			y := ys[i] + (k+0.5)*height
+			_, lat := mercatorToLonLat(0, y)
+			scale := math.Cos(lat * math.Pi / 180)
+			area += intervalOverlap(sweepA.intervals(y), sweepB.intervals(y)) * height * scale * scale
+		}
+	}
+	return area
+}
+
+// highest is the top of the highest edge.
+func highest(edges []edge) float64 {
+	top := math.Inf(-1)
+	for _, e := range edges {
+		top = math.Max(top, e.maxY())
+	}
+	return top
+}
+
+// crossingY is the y at which two edges cross, if they do.
+func crossingY(a, b edge) (float64, bool) {
+	dxA, dyA := a.x1-a.x0, a.y1-a.y0
+	dxB, dyB := b.x1-b.x0, b.y1-b.y0
+	denominator := dxA*dyB - dyA*dxB
+	if denominator == 0 {
+		return 0, false
+	}
+	t := ((b.x0-a.x0)*dyB - (b.y0-a.y0)*dxB) / denominator
+	u := ((b.x0-a.x0)*dyA - (b.y0-a.y0)*dxA) / denominator
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return a.y0 + t*dyA, true
+}
+
+// sweep keeps the edges that span the current y of a south to north sweep.
+type sweep struct {
+	edges  []edge
+	next   int
+	active []edge
+}
+
+func newSweep(edges []edge) *sweep {
+	return &sweep{edges: edges}
+}
+
+// intervals is where the horizontal line at y is inside the rings, as
+// sorted start and end pairs. y must not go down between calls.
+func (s *sweep) intervals(y float64) []float64 {
+	for s.next < len(s.edges) && s.edges[s.next].minY() <= y {
+		s.active = append(s.active, s.edges[s.next])
+		s.next++
+	}
+	kept := s.active[:0]
+	var xs []float64
+	for _, e := range s.active {
+		if e.maxY() <= y {
+			continue
+		}
+		kept = append(kept, e)
+		if e.minY() <= y {
+			xs = append(xs, e.x0+(y-e.y0)*(e.x1-e.x0)/(e.y1-e.y0))
+		}
+	}
+	s.active = kept
+	sort.Float64s(xs)
+	return xs[:len(xs)/2*2]
+}
+
+// intervalOverlap is the total length two sorted interval lists share.
+func intervalOverlap(a, b []float64) float64 {
+	length := 0.0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start := math.Max(a[i], b[j])
+		end := math.Min(a[i+1], b[j+1])
+		if end > start {
+			length += end - start
+		}
+		if a[i+1] < b[j+1] {
+			i += 2
+		} else {
+			j += 2
+		}
+	}
+	return length
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestOverlapArea(t *testing.T) {
+	square := box(-106, 35, -105, 36)
+	withHole := [][][]float64{square, reverseRing(box(-105.75, 35.25, -105.25, 35.75))}
+	tests := []struct {
+		name string
+		a, b [][][]float64
+		want float64
+	}{
+		{"same square", [][][]float64{square}, [][][]float64{square}, boxArea(-106, 35, -105, 36)},
+		{"half overlapping", [][][]float64{square}, [][][]float64{box(-105.5, 35, -104.5, 36)}, boxArea(-105.5, 35, -105, 36)},
+		{"corner overlapping", [][][]float64{square}, [][][]float64{box(-105.5, 35.5, -104.5, 36.5)}, boxArea(-105.5, 35.5, -105, 36)},
+		{"inside", [][][]float64{box(-105.9, 35.1, -105.1, 35.9)}, [][][]float64{square}, boxArea(-105.9, 35.1, -105.1, 35.9)},
+		{"disjoint", [][][]float64{square}, [][][]float64{box(-104, 35, -103, 36)}, 0},
+		{"touching", [][][]float64{square}, [][][]float64{box(-105, 35, -104, 36)}, 0},
+		{"hole is taken out", withHole, [][][]float64{square}, boxArea(-106, 35, -105, 36) - boxArea(-105.75, 35.25, -105.25, 35.75)},
+		{"inside the hole", withHole, [][][]float64{box(-105.6, 35.4, -105.4, 35.6)}, 0},
+		{"no rings", nil, [][][]float64{square}, 0},
+	}
+	for _, test := range tests {
+		if got := overlapArea(test.a, test.b); !closeTo(got, test.want, 1e-5) {
+			t.Errorf("%s: overlapArea = %f, want %f", test.name, got, test.want)
+		}
+	}
+}
+
+func TestOverlapAreaCrossingEdges(t *testing.T) {
+	//The triangle below the square's diagonal covers half of it. Its edges
+	//cross the square's, so the sweep has to stop at the crossings.
+	square := [][]float64{{0, 0}, {0, 1000}, {1000, 1000}, {1000, 0}, {0, 0}}
+	triangle := [][]float64{{-500, -500}, {1500, 1500}, {1500, -500}, {-500, -500}}
+	planar := overlapArea([][][]float64{square}, [][][]float64{square})
+	if got := overlapArea([][][]float64{square}, [][][]float64{triangle}); !closeTo(got, planar/2, 1e-6) {
+		t.Errorf("overlapArea = %f, want %f", got, planar/2)
+	}
+	if got := overlapArea([][][]float64{triangle}, [][][]float64{square}); !closeTo(got, planar/2, 1e-6) {
+		t.Errorf("overlapArea the other way round = %f, want %f", got, planar/2)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// writePDFReport lays the report out as a PDF and saves it to path: a cover
+// page, a table of contents and then every section, each with a bookmark.
+// The document properties carry the provenance of the report as well.
+func writePDFReport(report Report, path string) error {
+	pdf := reportTheme.newPDF()
+	pdf.SetTitle(report.Title, true)
+	pdf.SetAuthor(report.Requester, true)
+	pdf.SetSubject(pdfSubject(report), true)
+	pdf.SetKeywords(pdfKeywords(report), true)
+	pdf.SetCreator(versionText(), true)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pageFooter(pdf, report.ID)
+	})
+	coverPage(pdf, report)
+	links := contentsPage(pdf, report.Sections)
+	pdf.AddPage()
+	for i, content := range report.Sections {
+		sectionHeading(pdf, content.Title)
+		pdf.SetLink(links[i], pdf.GetY()-20, -1)
+		pdf.RegisterAlias(contentsAlias(i), strconv.Itoa(pdf.PageNo()))
+		sectionBlurb(pdf, content.Blurb)
+		for _, table := range content.Tables {
+			sectionTable(pdf, table)
+		}
+		for _, chart := range content.Charts {
+			sectionChart(pdf, chart)
+		}
+		for _, note := range content.Notes {
+			sectionBlurb(pdf, note)
+		}
+	}
+	return pdf.OutputFileAndClose(path)
+}
+
+// coverPage is the first page: the theme's header band and logo, the report
+// title and who the report was generated for, when.
+func coverPage(pdf *gofpdf.Fpdf, report Report) {
+	pdf.AddPage()
+	pdf.Bookmark(report.Title, 0, 0)
+	pageWidth, pageHeight := pdf.GetPageSize()
+	reportTheme.setHeaderFill(pdf)
+	pdf.Rect(0, 0, pageWidth, 20, "F")
+	if reportTheme.LogoPath != "" {
+		pdf.Image(reportTheme.LogoPath, 2, 2, 16, 16, false, "", 0, "")
+	}
+	width := reportTheme.contentWidth(pdf)
+	pdf.SetY(pageHeight / 4)
+	reportTheme.setFont(pdf, 35)
+	pdf.MultiCell(width, 15, report.Title, "", "C", false)
+	pdf.Ln(20)
+	reportTheme.setFont(pdf, 14)
+	for _, line := range [][2]string{
+		{"Area of interest", report.AreaText()},
+		{"Prepared for", report.Requester},
+		{"Generated", report.GeneratedText()},
+		{"Report ID", report.ID},
+	} {
+		if line[1] == "" {
+			continue
+		}
+		pdf.CellFormat(width/2-5, 9, line[0]+":", "", 0, "R", false, 0, "")
+		pdf.CellFormat(10, 9, "", "", 0, "", false, 0, "")
+		pdf.CellFormat(width/2-5, 9, line[1], "", 1, "L", false, 0, "")
+	}
+}
+
+// contentsPage lists the sections and links each entry to its section. The
+// page numbers aren't known yet, so they are written as aliases that
+// writePDFReport fills in as it reaches each section.
+func contentsPage(pdf *gofpdf.Fpdf, sections []SectionContent) []int {
+	pdf.AddPage()
+	sectionHeading(pdf, "Contents")
+	reportTheme.setFont(pdf, 12)
+	width := reportTheme.contentWidth(pdf)
+	links := make([]int, len(sections))
+	for i, content := range sections {
+		links[i] = pdf.AddLink()
+		pdf.CellFormat(width-20, 8, content.Title, "B", 0, "L", false, links[i], "")
+		pdf.CellFormat(20, 8, contentsAlias(i), "B", 1, "R", false, links[i], "")
+	}
+	return links
+}
+
+// contentsAlias is the placeholder for the page number of section i.
+func contentsAlias(i int) string {
+	return "{s" + strconv.Itoa(i) + "}"
+}
+
+// pageFooter writes the report ID, the theme's footer text and the page
+// number at the bottom of every page but the cover.
+func pageFooter(pdf *gofpdf.Fpdf, id string) {
+	if pdf.PageNo() == 1 {
+		return
+	}
+	_, _, _, bottom := pdf.GetMargins()
+	width := reportTheme.contentWidth(pdf) / 3
+	pdf.SetY(-bottom)
+	reportTheme.setFont(pdf, 8)
+	pdf.CellFormat(width, bottom/2, "Report "+id, "", 0, "LM", false, 0, "")
+	pdf.CellFormat(width, bottom/2, reportTheme.FooterText, "", 0, "CM", false, 0, "")
+	pdf.CellFormat(width, bottom/2, "Page "+strconv.Itoa(pdf.PageNo())+" of {nb}", "", 0, "RM", false, 0, "")
+}
+
+// sectionHeading writes the large centered title that starts every section
+// and bookmarks it. A heading near the bottom of a page starts a new page
+// rather than being left there on its own.
+func sectionHeading(pdf *gofpdf.Fpdf, title string) {
+	_, pageHeight := pdf.GetPageSize()
+	_, _, _, bottom := pdf.GetMargins()
+	if pdf.GetY()+60 > pageHeight-bottom {
+		pdf.AddPage()
+	}
+	pdf.Ln(15)
+	pdf.Bookmark(title, 0, -1)
+	reportTheme.setFont(pdf, 20)
+	pdf.CellFormat(reportTheme.contentWidth(pdf), 15, title, "0", 1, "CM", false, 0, "")
+	pdf.Ln(5)
+}
+
+// sectionBlurb writes the descriptive paragraph under a section heading.
+func sectionBlurb(pdf *gofpdf.Fpdf, blurb string) {
+	reportTheme.setFont(pdf, 11)
+	width := reportTheme.contentWidth(pdf)
+	lines := pdf.SplitLines([]byte(blurb), width)
+	_, lineHt := pdf.GetFontSize()
+	for _, line := range lines {
+		pdf.CellFormat(width, lineHt, string(line), "", 1, "TL", false, 0, "")
+	}
+}
+
+// Table layout, in mm and points.
+const (
+	tableHeaderSize = 10.0
+	tableBodySize   = 8.0
+	tableCellPad    = 1.5
+)
+
+var tableStripe = []int{242, 242, 242}
+
+// sectionTable lays out a table with its text wrapped to the column widths.
+// Each row is as tall as its longest cell, rows never split across pages and
+// the header is repeated at the top of every page the table runs onto. The
+// widths are stretched from 190mm to the theme's content width. Nothing is
+// drawn when there are no rows.
+func sectionTable(pdf *gofpdf.Fpdf, table ContentTable) {
+	if len(table.Rows) == 0 {
+		return
+	}
+	scale := reportTheme.contentWidth(pdf) / 190
+	widths := make([]float64, len(table.Widths))
+	for i, width := range table.Widths {
+		widths[i] = width * scale
+	}
+	pdf.Ln(3)
+	if table.Caption != "" {
+		reportTheme.setFont(pdf, 12)
+		_, _, _, bottom := pdf.GetMargins()
+		_, pageHeight := pdf.GetPageSize()
+		if pdf.GetY()+30 > pageHeight-bottom {
+			pdf.AddPage()
+		}
+		pdf.CellFormat(reportTheme.contentWidth(pdf), 8, table.Caption, "", 1, "LM", false, 0, "")
+	}
+	tableHeader(pdf, table, widths)
+	_, pageHeight := pdf.GetPageSize()
+	_, _, _, bottom := pdf.GetMargins()
+	for i, row := range table.Rows {
+		reportTheme.setFont(pdf, tableBodySize)
+		lines, height := tableCells(pdf, row, widths)
+		if pdf.GetY()+height > pageHeight-bottom {
+			pdf.AddPage()
+			tableHeader(pdf, table, widths)
+			reportTheme.setFont(pdf, tableBodySize)
+		}
+		pdf.SetFillColor(tableStripe[0], tableStripe[1], tableStripe[2])
+		tableRow(pdf, table, widths, lines, height, i%2 == 1)
+	}
+}
+
+// tableHeader draws the header row in the theme's header colour.
+func tableHeader(pdf *gofpdf.Fpdf, table ContentTable, widths []float64) {
+	reportTheme.setFont(pdf, tableHeaderSize)
+	lines, height := tableCells(pdf, table.Headers, widths)
+	reportTheme.setHeaderFill(pdf)
+	centred := ContentTable{Align: make([]string, len(table.Headers))}
+	for i := range centred.Align {
+		centred.Align[i] = "C"
+	}
+	tableRow(pdf, centred, widths, lines, height, true)
+}
+
+// tableCells wraps each cell of a row to its column and returns the lines
+// and the height of the row in the current font.
+func tableCells(pdf *gofpdf.Fpdf, cells []string, widths []float64) ([][]string, float64) {
+	_, lineHeight := pdf.GetFontSize()
+	lineHeight *= 1.2
+	lines := make([][]string, len(cells))
+	most := 1
+	for i, cell := range cells {
+		for _, line := range pdf.SplitLines([]byte(cell), widths[i]-2*tableCellPad) {
+			lines[i] = append(lines[i], string(line))
+		}
+		if len(lines[i]) > most {
+			most = len(lines[i])
+		}
+	}
+	return lines, float64(most)*lineHeight + 2*tableCellPad
+}
+
+// tableRow draws one row of wrapped cells with borders, filled with the
+// current fill colour when fill is set, and moves below it.
+func tableRow(pdf *gofpdf.Fpdf, table ContentTable, widths []float64, lines [][]string, height float64, fill bool) {
+	_, lineHeight := pdf.GetFontSize()
+	lineHeight *= 1.2
+	style := "D"
+	if fill {
+		style = "FD"
+	}
+	x, y := pdf.GetX(), pdf.GetY()
+	for i, cellLines := range lines {
+		pdf.Rect(x, y, widths[i], height, style)
+		for j, line := range cellLines {
+			pdf.SetXY(x+tableCellPad, y+tableCellPad+float64(j)*lineHeight)
+			pdf.CellFormat(widths[i]-2*tableCellPad, lineHeight, line, "", 0, table.align(i)+"M", false, 0, "")
+		}
+		x += widths[i]
+	}
+	left, _, _, _ := pdf.GetMargins()
+	pdf.SetXY(left, y+height)
+}
+
+// sectionChart places a chart image centered on the page, starting a new page
+// if it doesn't fit under the current position. Charts too big for the
+// theme's page are scaled down to fit.
+func sectionChart(pdf *gofpdf.Fpdf, chart ContentChart) {
+	var options gofpdf.ImageOptions
+	options.ImageType = "PNG"
+	pdf.RegisterImageOptionsReader(chart.Name, options, bytes.NewReader(chart.PNG))
+
+	_, pageHeight := pdf.GetPageSize()
+	left, top, _, bottom := pdf.GetMargins()
+	width, height := chart.Width, chart.Height
+	contentWidth, contentHeight := reportTheme.contentWidth(pdf), pageHeight-top-bottom
+	if width > contentWidth {
+		width, height = contentWidth, height*contentWidth/width
+	}
+	if height > contentHeight {
+		width, height = width*contentHeight/height, contentHeight
+	}
+	if pdf.GetY()+height > pageHeight-bottom {
+		pdf.AddPage()
+	}
+	CurrentY := pdf.GetY()
+	if pdf.Ok() {
+		pdf.Image(chart.Name, left+(contentWidth-width)/2, CurrentY, width, height, false, "", 0, "")
+		pdf.SetY(CurrentY + height)
+	}
+}
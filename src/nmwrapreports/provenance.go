@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LayerSource records where one section's data came from and how old it is,
+// for the provenance appendix and the PDF metadata.
+type LayerSource struct {
+	Title    string
+	Name     string // layer name as published, from its metadata
+	URL      string
+	Status   LayerStatus
+	Features int
+	Queried  time.Time
+	LoadDate time.Time // latest LOADDATE of the features, if the layer has one
+	LastEdit time.Time // last edit of the layer, from its metadata
+}
+
+// DataDate is the best date for the vintage of the data: LOADDATE when the
+// features carry one, otherwise the layer's last edit. Blank when neither is known.
+func (s LayerSource) DataDate() string {
+	switch {
+	case !s.LoadDate.IsZero():
+		return s.LoadDate.Format("2006-01-02") + " (LOADDATE)"
+	case !s.LastEdit.IsZero():
+		return s.LastEdit.Format("2006-01-02") + " (last edit)"
+	}
+	return ""
+}
+
+// layerInfoAge is how long a layer's metadata is reused before it is read
+// again.
+const layerInfoAge = 15 * time.Minute
+
+// layerInfoCache is the metadata of every layer read recently, by layer URL,
+// so reports don't ask ArcGIS for it each time.
+var layerInfoCache = struct {
+	sync.Mutex
+	layers map[string]cachedLayerInfo
+}{layers: map[string]cachedLayerInfo{}}
+
+type cachedLayerInfo struct {
+	info layerInfo
+	read time.Time
+}
+
+// queryLayerInfo reads a layer's metadata, from layerInfoCache when it was
+// read in the last layerInfoAge. A failure only leaves the provenance
+// appendix without the layer's name and edit date, so it is not reported,
+// and the next report tries again.
+func queryLayerInfo(ctx context.Context, query SectionQuery) layerInfo {
+	layerurl := strings.TrimRight(query.Service, "/") + "/" + strconv.Itoa(query.LayerID)
+	layerInfoCache.Lock()
+	cached, ok := layerInfoCache.layers[layerurl]
+	layerInfoCache.Unlock()
+	if ok && time.Since(cached.read) < layerInfoAge {
+		return cached.info
+	}
+	var info layerInfo
+	if ctx.Err() != nil {
+		return info
+	}
+	client := &http.Client{Timeout: layerTimeout}
+	if err := getJSON(ctx, client, layerurl+"?f=json", &info); err != nil || info.Name == "" {
+		//An ArcGIS error payload decodes to empty metadata, which isn't kept either.
+		return layerInfo{}
+	}
+	layerInfoCache.Lock()
+	layerInfoCache.layers[layerurl] = cachedLayerInfo{info: info, read: time.Now()}
+	layerInfoCache.Unlock()
+	return info
+}
+
+// layerSources describes the data behind every section, in report order.
+func layerSources(sections []ReportSection, queries []SectionQuery, results []layerResult) []LayerSource {
+	sources := make([]LayerSource, len(sections))
+	for i, section := range sections {
+		sources[i] = LayerSource{
+			Title:   section.Title(),
+			Name:    results[i].Info.Name,
+			URL:     strings.TrimRight(queries[i].Service, "/") + "/" + strconv.Itoa(queries[i].LayerID),
+			Status:  results[i].Status,
+			Queried: results[i].Queried,
+		}
+		if results[i].Info.EditingInfo.LastEditDate > 0 {
+			sources[i].LastEdit = epochMillis(results[i].Info.EditingInfo.LastEditDate)
+		}
+		if results[i].Status != LayerOK {
+			continue
+		}
+		var features esriFeatureSet
+		if err := json.Unmarshal(results[i].Body, &features); err != nil {
+			continue
+		}
+		sources[i].Features = len(features.Features)
+		for _, feature := range features.Features {
+			if date, ok := loadDate(feature.Attributes["LOADDATE"]); ok && date.After(sources[i].LoadDate) {
+				sources[i].LoadDate = date
+			}
+		}
+	}
+	return sources
+}
+
+// epochMillis converts an ArcGIS date, in milliseconds since 1970, to a time.
+func epochMillis(ms int64) time.Time {
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC()
+}
+
+// loadDateLayouts are the text forms LOADDATE has been published in.
+var loadDateLayouts = []string{"2006-01-02", "2006-01-02 15:04:05", "1/2/2006", "1/2/2006 3:04:05 PM", "20060102", time.RFC3339}
+
+// loadDate reads a LOADDATE attribute, which is an ArcGIS date on some layers
+// and text on others.
+func loadDate(v interface{}) (time.Time, bool) {
+	switch value := v.(type) {
+	case float64:
+		if value <= 0 {
+			return time.Time{}, false
+		}
+		return epochMillis(int64(value)), true
+	case string:
+		for _, layout := range loadDateLayouts {
+			if date, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+				return date, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// spatialReferenceText names the spatial reference of the area of interest.
+func spatialReferenceText(aoi Geom) string {
+	wkid := 102100
+	if aoi.SpatialReference != nil && aoi.SpatialReference.Wkid != 0 {
+		wkid = aoi.SpatialReference.Wkid
+	}
+	switch wkid {
+	case 102100, 3857:
+		return "WGS 84 / Web Mercator (wkid " + strconv.Itoa(wkid) + ")"
+	case 4326:
+		return "WGS 84 (wkid 4326)"
+	}
+	return "wkid " + strconv.Itoa(wkid)
+}
+
+// versionText is the build of nmwrapreports that made the report.
+func versionText() string {
+	return "nmwrapreports " + VERSION + " (" + CODENAME + ")"
+}
+
+// provenanceAppendix lists where every section's data came from, when it was
+// queried and how old it is, so reviewers can tell which vintage of the data
+// a report used.
+func provenanceAppendix(aoi Geom, sources []LayerSource) SectionContent {
+	var rows [][]string
+	for _, source := range sources {
+		name := source.Name
+		if name == "" {
+			name = source.Title
+		}
+		features := strconv.Itoa(source.Features)
+		if source.Status != LayerOK {
+			features = source.Status.String()
+		}
+		queried := ""
+		if !source.Queried.IsZero() {
+			queried = source.Queried.Format("2006-01-02 15:04:05 MST")
+		}
+		rows = append(rows, []string{name, source.URL, features, queried, source.DataDate()})
+	}
+	return SectionContent{
+		Title: "Appendix: data sources",
+		Blurb: "Each section of this report was queried live from the layer below. The data date is the latest LOADDATE of the features returned where the layer has one, and otherwise the date the layer was last edited.",
+		Tables: []ContentTable{
+			{
+				Widths:  []float64{32, 70, 22, 36, 30},
+				Headers: []string{"Layer", "Service URL", "Features", "Queried", "Data date"},
+				Rows:    rows,
+				Align:   []string{"L", "L", "R", "L", "L"},
+			},
+			{
+				Widths:  []float64{60, 130},
+				Headers: []string{"Report", "Value"},
+				Rows: [][]string{
+					{"Area of interest spatial reference", spatialReferenceText(aoi)},
+					{"Generated by", versionText()},
+				},
+			},
+		},
+	}
+}
+
+// pdfSubject and pdfKeywords summarise the provenance for the PDF metadata.
+func pdfSubject(report Report) string {
+	return "NMWRAP wildfire risk report for an area of " + report.AreaText() + " (" + spatialReferenceText(report.AOI) + "), generated " + report.GeneratedText()
+}
+
+func pdfKeywords(report Report) string {
+	keywords := []string{"NMWRAP", "wildfire risk"}
+	for _, source := range report.Sources {
+		keyword := source.Title
+		if date := source.DataDate(); date != "" {
+			keyword += " " + date
+		}
+		keywords = append(keywords, keyword)
+	}
+	return strings.Join(keywords, "; ")
+}
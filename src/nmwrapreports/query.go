@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queryWorkers, layerTimeout and maxLayerFeatures are set from the config in main.
+var queryWorkers = 4
+var layerTimeout = 30 * time.Second
+var maxLayerFeatures = 5000
+
+// LayerStatus says whether a layer's data could be used in the report.
+type LayerStatus int
+
+const (
+	// LayerOK means the layer was queried and decoded.
+	LayerOK LayerStatus = iota
+	// LayerUnreachable means the request failed or timed out.
+	LayerUnreachable
+	// LayerServiceError means ArcGIS answered with an error payload.
+	LayerServiceError
+	// LayerUnreadable means the response could not be decoded.
+	LayerUnreadable
+)
+
+func (s LayerStatus) String() string {
+	switch s {
+	case LayerOK:
+		return "OK"
+	case LayerUnreachable:
+		return "service unreachable"
+	case LayerServiceError:
+		return "service error"
+	case LayerUnreadable:
+		return "unreadable response"
+	}
+	return "unknown"
+}
+
+// ArcGISError is the error object ArcGIS Server returns in place of a result.
+type ArcGISError struct {
+	Code    int      `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details"`
+}
+
+func (e *ArcGISError) Error() string {
+	msg := fmt.Sprintf("ArcGIS error %d: %s", e.Code, e.Message)
+	if len(e.Details) > 0 {
+		msg += " (" + strings.Join(e.Details, "; ") + ")"
+	}
+	return msg
+}
+
+// arcgisError returns the error in an ArcGIS response body, if there is one.
+func arcgisError(body []byte) error {
+	var envelope struct {
+		Error *ArcGISError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return err
+	}
+	if envelope.Error != nil {
+		return envelope.Error
+	}
+	return nil
+}
+
+// layerResult is the response to one section's layer query.
+type layerResult struct {
+	Status    LayerStatus
+	Body      []byte
+	Truncated bool
+	Err       error
+	Queried   time.Time // when the query was sent
+	Info      layerInfo // the layer's metadata, empty if it couldn't be read
+}
+
+// queryLayers runs every layer query using at most queryWorkers concurrent
+// requests. Results are returned in the order of queries so the report always
+// renders the same way. Cancelling ctx stops any queries still running.
+func queryLayers(ctx context.Context, geom []byte, queries []SectionQuery) []layerResult {
+	results := make([]layerResult, len(queries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < queryWorkers && w < len(queries); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := ctx.Err(); err != nil {
+					results[i] = layerResult{Status: LayerUnreachable, Err: err}
+					continue
+				}
+				queried := time.Now()
+				results[i] = newLayerResult(queryLayer(ctx, geom, queries[i]))
+				results[i].Queried = queried
+				results[i].Info = queryLayerInfo(ctx, queries[i])
+			}
+		}()
+	}
+	for i := range queries {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// newLayerResult works out the status of a layer query from its outcome.
+func newLayerResult(body []byte, truncated bool, err error) layerResult {
+	switch err.(type) {
+	case nil:
+		return layerResult{Status: LayerOK, Body: body, Truncated: truncated}
+	case *ArcGISError:
+		return layerResult{Status: LayerServiceError, Err: err}
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return layerResult{Status: LayerUnreadable, Err: err}
+	default:
+		return layerResult{Status: LayerUnreachable, Err: err}
+	}
+}
+
+// featurePage is the part of a query response needed to page through results.
+type featurePage struct {
+	ExceededTransferLimit bool              `json:"exceededTransferLimit"`
+	Features              []json.RawMessage `json:"features"`
+	ObjectIDFieldName     string            `json:"objectIdFieldName"`
+	Fields                []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"fields"`
+}
+
+// objectIDField is the name of the layer's object id field, or empty when the
+// response doesn't say.
+func (p featurePage) objectIDField() string {
+	if p.ObjectIDFieldName != "" {
+		return p.ObjectIDFieldName
+	}
+	for _, field := range p.Fields {
+		if field.Type == "esriFieldTypeOID" {
+			return field.Name
+		}
+	}
+	return ""
+}
+
+//queryLayer runs a section's query against its MapServer layer and returns the raw response.
+//When ArcGIS stops at its transfer limit the remaining features are fetched
+//page by page, up to maxLayerFeatures, and merged into the one response.
+//truncated is set when the layer had more features than that.
+//Each request is abandoned after layerTimeout, and the whole query when ctx
+//is cancelled.
+func queryLayer(ctx context.Context, geom []byte, query SectionQuery) (body []byte, truncated bool, err error) {
+	queryurl := strings.TrimRight(query.Service, "/") + "/" + strconv.Itoa(query.LayerID) + "/query"
+	body, err = postQuery(ctx, queryurl, queryForm(geom, query))
+	if err != nil {
+		return nil, false, err
+	}
+	var first featurePage
+	if err := json.Unmarshal(body, &first); err != nil {
+		return nil, false, err
+	}
+	if !first.ExceededTransferLimit && len(first.Features) <= maxLayerFeatures {
+		return body, false, nil
+	}
+	var features []json.RawMessage
+	orderBy := first.objectIDField()
+	if orderBy != "" {
+		features, truncated, err = pageByOffset(ctx, queryurl, geom, query, orderBy, len(first.Features))
+	}
+	if _, ok := err.(*ArcGISError); ok || orderBy == "" {
+		//Older services and some layer types do not support resultOffset, and
+		//without an object id to order by the pages could overlap.
+		features, truncated, err = pageByObjectIDs(ctx, queryurl, geom, query, len(first.Features))
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	body, err = mergePages(body, features, truncated)
+	return body, truncated, err
+}
+
+//pageByOffset fetches a layer with resultOffset/resultRecordCount, pageSize
+//features at a time. ArcGIS only keeps the order stable between pages when
+//asked for one, so the pages are ordered by the object id field orderBy and
+//the unordered first response is fetched again.
+func pageByOffset(ctx context.Context, queryurl string, geom []byte, query SectionQuery, orderBy string, pageSize int) ([]json.RawMessage, bool, error) {
+	var features []json.RawMessage
+	more := true
+	for more && len(features) < maxLayerFeatures && pageSize > 0 {
+		form := queryForm(geom, query)
+		form.Set("orderByFields", orderBy)
+		form.Set("resultOffset", strconv.Itoa(len(features)))
+		form.Set("resultRecordCount", strconv.Itoa(pageSize))
+		body, err := postQuery(ctx, queryurl, form)
+		if err != nil {
+			return nil, false, err
+		}
+		var page featurePage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, false, err
+		}
+		if len(page.Features) == 0 {
+			break
+		}
+		features = append(features, page.Features...)
+		more = page.ExceededTransferLimit
+	}
+	return capFeatures(features, more)
+}
+
+//pageByObjectIDs asks for every matching object id and then fetches the
+//features in batches of batchSize ids.
+func pageByObjectIDs(ctx context.Context, queryurl string, geom []byte, query SectionQuery, batchSize int) ([]json.RawMessage, bool, error) {
+	form := queryForm(geom, query)
+	form.Set("returnIdsOnly", "true")
+	body, err := postQuery(ctx, queryurl, form)
+	if err != nil {
+		return nil, false, err
+	}
+	var ids struct {
+		ObjectIDs []int `json:"objectIds"`
+	}
+	if err := json.Unmarshal(body, &ids); err != nil {
+		return nil, false, err
+	}
+	sort.Ints(ids.ObjectIDs)
+	truncated := len(ids.ObjectIDs) > maxLayerFeatures
+	if truncated {
+		ids.ObjectIDs = ids.ObjectIDs[:maxLayerFeatures]
+	}
+	if batchSize < 1 {
+		batchSize = 1000
+	}
+	var features []json.RawMessage
+	for start := 0; start < len(ids.ObjectIDs); start += batchSize {
+		end := start + batchSize
+		if end > len(ids.ObjectIDs) {
+			end = len(ids.ObjectIDs)
+		}
+		batch := make([]string, 0, end-start)
+		for _, id := range ids.ObjectIDs[start:end] {
+			batch = append(batch, strconv.Itoa(id))
+		}
+		form := queryForm(geom, query)
+		form.Del("geometry")
+		form.Del("geometryType")
+		form.Del("spatialRel")
+		form.Del("distance")
+		form.Del("units")
+		form.Set("objectIds", strings.Join(batch, ","))
+		body, err := postQuery(ctx, queryurl, form)
+		if err != nil {
+			return nil, false, err
+		}
+		var page featurePage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, false, err
+		}
+		features = append(features, page.Features...)
+	}
+	return features, truncated, nil
+}
+
+//capFeatures trims features to maxLayerFeatures. more says whether the service had more to give.
+func capFeatures(features []json.RawMessage, more bool) ([]json.RawMessage, bool, error) {
+	if len(features) > maxLayerFeatures {
+		return features[:maxLayerFeatures], true, nil
+	}
+	return features, more, nil
+}
+
+//mergePages puts the collected features back into the first response so
+//sections can decode it like any other. exceededTransferLimit is left set
+//only when the feature cap was hit.
+func mergePages(first []byte, features []json.RawMessage, truncated bool) ([]byte, error) {
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(first, &merged); err != nil {
+		return nil, err
+	}
+	var err error
+	if merged["features"], err = json.Marshal(features); err != nil {
+		return nil, err
+	}
+	if merged["exceededTransferLimit"], err = json.Marshal(truncated); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
+
+//queryForm is the form for a layer query with the user's geometry. Feature
+//geometry is always asked for in Web Mercator, the same as the user's geometry.
+func queryForm(geom []byte, query SectionQuery) url.Values {
+	form := url.Values{
+		"f":                    {"pjson"},
+		"geometry":             {string(geom)},
+		"geometryType":         {"esriGeometryPolygon"},
+		"outFields":            {query.OutFields},
+		"returnCountOnly":      {"false"},
+		"returnDistinctValues": {"false"},
+		"returnGeometry":       {strconv.FormatBool(query.ReturnGeometry)},
+		"returnIdsOnly":        {"false"},
+		"returnM":              {"false"},
+		"returnTrueCurves":     {"false"},
+		"returnZ":              {"false"},
+		"spatialRel":           {"esriSpatialRelIntersects"}}
+	if query.ReturnGeometry {
+		form.Set("outSR", "102100")
+	}
+	if query.Distance > 0 {
+		form.Set("distance", strconv.FormatFloat(query.Distance, 'f', 0, 64))
+		form.Set("units", "esriSRUnit_Meter")
+	}
+	return form
+}
+
+//postQuery posts a query form to ArcGIS and returns the body. ArcGIS error
+//payloads are returned as an *ArcGISError. The request is given up on after
+//layerTimeout.
+func postQuery(ctx context.Context, queryurl string, form url.Values) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, layerTimeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", queryurl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", queryurl, resp.Status)
+	}
+	if err := arcgisError(body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
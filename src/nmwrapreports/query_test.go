@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeLayer is an ArcGIS layer query endpoint with features OBJECTID 1 to
+// Features that returns at most PageSize of them at a time. The first,
+// unordered response has them newest first.
+type fakeLayer struct {
+	Features int
+	PageSize int
+	Offsets  bool // whether resultOffset is supported
+	NoOID    bool // whether the response leaves out the object id field
+	Requests int
+}
+
+func (l *fakeLayer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.Requests++
+	r.ParseForm()
+	var ids []int
+	exceeded := false
+	switch {
+	case r.FormValue("returnIdsOnly") == "true":
+		for id := l.Features; id > 0; id-- {
+			ids = append(ids, id)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"objectIdFieldName": "OBJECTID", "objectIds": ids})
+		return
+	case r.FormValue("objectIds") != "":
+		for _, text := range strings.Split(r.FormValue("objectIds"), ",") {
+			id, _ := strconv.Atoi(text)
+			ids = append(ids, id)
+		}
+	case r.FormValue("resultOffset") != "":
+		if !l.Offsets {
+			fmt.Fprint(w, `{"error":{"code":400,"message":"Pagination is not supported."}}`)
+			return
+		}
+		if r.FormValue("orderByFields") != "OBJECTID" {
+			fmt.Fprint(w, `{"error":{"code":400,"message":"Pages must be ordered."}}`)
+			return
+		}
+		offset, _ := strconv.Atoi(r.FormValue("resultOffset"))
+		count, _ := strconv.Atoi(r.FormValue("resultRecordCount"))
+		if count > l.PageSize {
+			count = l.PageSize
+		}
+		for id := offset + 1; id <= l.Features && id <= offset+count; id++ {
+			ids = append(ids, id)
+		}
+		exceeded = offset+count < l.Features
+	default:
+		for id := l.Features; id > 0 && len(ids) < l.PageSize; id-- {
+			ids = append(ids, id)
+		}
+		exceeded = l.Features > l.PageSize
+	}
+	page := map[string]interface{}{"exceededTransferLimit": exceeded, "features": featuresWithIDs(ids)}
+	if !l.NoOID {
+		page["objectIdFieldName"] = "OBJECTID"
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func featuresWithIDs(ids []int) []map[string]interface{} {
+	features := []map[string]interface{}{}
+	for _, id := range ids {
+		features = append(features, map[string]interface{}{"attributes": map[string]int{"OBJECTID": id}})
+	}
+	return features
+}
+
+// pageIDs are the object ids of the features in a query response, in order.
+func pageIDs(t *testing.T, body []byte) (ids []int, exceeded bool) {
+	var page struct {
+		ExceededTransferLimit bool `json:"exceededTransferLimit"`
+		Features              []struct {
+			Attributes struct {
+				OBJECTID int
+			} `json:"attributes"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatal(err)
+	}
+	for _, feature := range page.Features {
+		ids = append(ids, feature.Attributes.OBJECTID)
+	}
+	return ids, page.ExceededTransferLimit
+}
+
+func TestQueryLayerPaging(t *testing.T) {
+	defer func(max int) { maxLayerFeatures = max }(maxLayerFeatures)
+	tests := []struct {
+		name       string
+		layer      fakeLayer
+		max        int
+		features   int // the first features, in object id order
+		truncated  bool
+		requests   int
+		descending bool // the unpaged first response is kept as it is
+	}{
+		{"one page", fakeLayer{Features: 7, PageSize: 10, Offsets: true}, 100, 7, false, 1, true},
+		{"offset pages", fakeLayer{Features: 25, PageSize: 10, Offsets: true}, 100, 25, false, 4, false},
+		{"no pagination falls back to object ids", fakeLayer{Features: 25, PageSize: 10}, 100, 25, false, 6, false},
+		{"no object id field falls back to object ids", fakeLayer{Features: 25, PageSize: 10, Offsets: true, NoOID: true}, 100, 25, false, 5, false},
+		{"offset pages capped", fakeLayer{Features: 25, PageSize: 10, Offsets: true}, 12, 12, true, 3, false},
+		{"offset pages capped on a page", fakeLayer{Features: 25, PageSize: 10, Offsets: true}, 20, 20, true, 3, false},
+		{"object ids capped", fakeLayer{Features: 25, PageSize: 10}, 12, 12, true, 5, false},
+	}
+	for _, test := range tests {
+		layer := test.layer
+		server := httptest.NewServer(&layer)
+		maxLayerFeatures = test.max
+		body, truncated, err := queryLayer(context.Background(), []byte(`{"rings":[]}`), SectionQuery{Service: server.URL, LayerID: 3, OutFields: "*"})
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		ids, exceeded := pageIDs(t, body)
+		if len(ids) != test.features {
+			t.Errorf("%s: %d features, want %d", test.name, len(ids), test.features)
+		}
+		for i, id := range ids {
+			want := i + 1
+			if test.descending {
+				want = test.layer.Features - i
+			}
+			if id != want {
+				t.Errorf("%s: feature %d has OBJECTID %d, want %d", test.name, i, id, want)
+				break
+			}
+		}
+		if truncated != test.truncated || exceeded != test.truncated {
+			t.Errorf("%s: truncated %t and exceededTransferLimit %t, want %t", test.name, truncated, exceeded, test.truncated)
+		}
+		if layer.Requests != test.requests {
+			t.Errorf("%s: %d requests, want %d", test.name, layer.Requests, test.requests)
+		}
+	}
+}
+
+func TestQueryLayerServiceError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"error":{"code":500,"message":"Unable to complete operation."}}`)
+	}))
+	defer server.Close()
+	_, _, err := queryLayer(context.Background(), []byte(`{"rings":[]}`), SectionQuery{Service: server.URL})
+	if result := newLayerResult(nil, false, err); result.Status != LayerServiceError {
+		t.Errorf("status %v for %v, want %v", result.Status, err, LayerServiceError)
+	}
+}
+
+func TestCapFeatures(t *testing.T) {
+	defer func(max int) { maxLayerFeatures = max }(maxLayerFeatures)
+	maxLayerFeatures = 3
+	features := func(n int) []json.RawMessage {
+		return make([]json.RawMessage, n)
+	}
+	tests := []struct {
+		name      string
+		features  int
+		more      bool
+		want      int
+		truncated bool
+	}{
+		{"under the cap", 2, false, 2, false},
+		{"at the cap", 3, false, 3, false},
+		{"at the cap with more to come", 3, true, 3, true},
+		{"over the cap", 5, false, 3, true},
+	}
+	for _, test := range tests {
+		capped, truncated, _ := capFeatures(features(test.features), test.more)
+		if len(capped) != test.want || truncated != test.truncated {
+			t.Errorf("%s: %d features, truncated %t, want %d, %t", test.name, len(capped), truncated, test.want, test.truncated)
+		}
+	}
+}
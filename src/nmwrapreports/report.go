@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SectionContent is what a section shows in the report, independent of the
+// output format. The PDF and HTML writers both draw from it so they always
+// carry the same information.
+type SectionContent struct {
+	Title  string
+	Blurb  string
+	Tables []ContentTable
+	Charts []ContentChart
+	Notes  []string
+}
+
+// ContentTable is a table of text. Widths are the column widths in mm on the
+// PDF page (190 wide); the HTML report uses them as proportions. Align holds
+// L, C or R for each column and defaults to L. Caption is an optional line
+// shown above the table.
+type ContentTable struct {
+	Caption string
+	Widths  []float64
+	Headers []string
+	Rows    [][]string
+	Align   []string
+}
+
+// align is the alignment of column i.
+func (t ContentTable) align(i int) string {
+	if i < len(t.Align) && t.Align[i] != "" {
+		return t.Align[i]
+	}
+	return "L"
+}
+
+// ContentChart is a rendered chart image. Name must be unique within a report.
+// Width and Height are the size in mm on the PDF page.
+type ContentChart struct {
+	Name   string
+	PNG    []byte
+	Width  float64
+	Height float64
+}
+
+// Report is everything that goes into one generated report.
+type Report struct {
+	ID        string
+	Title     string
+	Requester string
+	Generated time.Time
+	Sections  []SectionContent
+	Records   []SectionRecords
+	Layers    []LayerFeatures
+	AOI       Geom
+	Units     areaUnit
+	Sources   []LayerSource
+}
+
+// acreMetres is the size of an acre in square metres.
+const acreMetres = 4046.8564224
+
+// AreaText is the size of the area of interest in the requested units.
+func (r Report) AreaText() string {
+	return r.Units.area(geodesicArea(r.AOI.Rings))
+}
+
+// GeneratedText is when the report was generated, for the cover.
+func (r Report) GeneratedText() string {
+	return r.Generated.Format("January 2, 2006 15:04 MST")
+}
+
+// formatNumber formats v with thousands separators.
+func formatNumber(v float64, decimals int) string {
+	text := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	whole, fraction := text, ""
+	if i := strings.Index(text, "."); i >= 0 {
+		whole, fraction = text[:i], text[i:]
+	}
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+	if v < 0 {
+		whole = "-" + whole
+	}
+	return whole + fraction
+}
+
+// ReportOptions are the per-request choices for ReportGen. Units is acres,
+// hectares or sqmi, and picks the length unit too: miles for acres and square
+// miles, kilometres for hectares.
+type ReportOptions struct {
+	Format string `json:"format"`
+	Units  string `json:"units"`
+}
+
+// reportWriter writes a Report to disk in one output format.
+type reportWriter struct {
+	Ext   string
+	Write func(report Report, path string) error
+}
+
+// reportWriters are the supported values of the format option.
+var reportWriters = map[string]reportWriter{
+	"pdf":     {".pdf", writePDFReport},
+	"html":    {".html", writeHTMLReport},
+	"csv":     {".csv.zip", writeCSVExport},
+	"xlsx":    {".xlsx", writeXLSXExport},
+	"geojson": {".geojson.zip", writeGeoJSONExport},
+}
+
+// ReportOptionsFromJSON reads the options sent alongside the geometry on /postgeom.
+func ReportOptionsFromJSON(body []byte) (ReportOptions, error) {
+	var opts ReportOptions
+	json.Unmarshal(body, &opts)
+	return opts, opts.check()
+}
+
+// ReportOptionsFromForm reads the options from an upload form.
+func ReportOptionsFromForm(r *http.Request) (ReportOptions, error) {
+	opts := ReportOptions{Format: r.FormValue("format"), Units: r.FormValue("units")}
+	return opts, opts.check()
+}
+
+// needsGeometry says whether the layer queries must return feature geometry.
+func (opts ReportOptions) needsGeometry() bool {
+	return opts.Format == "geojson"
+}
+
+// check fills in defaults and rejects options ReportGen can't honour.
+func (opts *ReportOptions) check() error {
+	opts.Format = strings.ToLower(opts.Format)
+	if opts.Format == "" {
+		opts.Format = "pdf"
+	}
+	if _, ok := reportWriters[opts.Format]; !ok {
+		return errors.New("Unknown report format " + opts.Format)
+	}
+	opts.Units = strings.ToLower(opts.Units)
+	if opts.Units == "" {
+		opts.Units = "acres"
+	}
+	if _, ok := areaUnits[opts.Units]; !ok {
+		return errors.New("Unknown units " + opts.Units)
+	}
+	return nil
+}
+
+// areaUnit is the unit areas and lengths are reported in.
+func (opts ReportOptions) areaUnit() areaUnit {
+	return areaUnits[opts.Units]
+}
+
+// reportPath is where a report with the given key is kept for download. The
+// file type is picked from the extension of the requested download name, so
+// /getreport/{key}/report.html serves the HTML version. Anything else is
+// treated as a PDF, as it always was.
+func reportPath(key string, fname string) string {
+	ext := ".pdf"
+	for _, writer := range reportWriters {
+		if strings.HasSuffix(fname, writer.Ext) && len(writer.Ext) > len(ext) {
+			ext = writer.Ext
+		}
+	}
+	return "/tmp/" + key + ext
+}
+
+// isReportFile says whether a file in /tmp is a generated report or a batch
+// of them.
+func isReportFile(name string) bool {
+	if strings.HasSuffix(name, batchExt) {
+		return true
+	}
+	for _, writer := range reportWriters {
+		if strings.HasSuffix(name, writer.Ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportRecords collects the exportable records of every section whose layer came back.
+func reportRecords(sections []ReportSection, results []layerResult) []SectionRecords {
+	var records []SectionRecords
+	for i, section := range sections {
+		if results[i].Status == LayerOK {
+			records = append(records, section.Records())
+		}
+	}
+	return records
+}
+
+// exportedSection is a ReportSection that exports only part of its query
+// response, such as the fire stations the report lists out of all those the
+// search around the area found.
+type exportedSection interface {
+	ExportBody(body []byte) ([]byte, error)
+}
+
+// reportLayers keeps the raw response of every section whose layer came back.
+func reportLayers(sections []ReportSection, results []layerResult) []LayerFeatures {
+	var layers []LayerFeatures
+	for i, section := range sections {
+		if results[i].Status != LayerOK {
+			continue
+		}
+		body := results[i].Body
+		if exported, ok := section.(exportedSection); ok {
+			var err error
+			if body, err = exported.ExportBody(body); err != nil {
+				log.Println(section.Title()+": ", err)
+				continue
+			}
+		}
+		layers = append(layers, LayerFeatures{Name: section.Title(), Body: body})
+	}
+	return layers
+}
+
+// reportContent collects the content of every section in report order. The
+// executive summary comes first, then a data availability section when any
+// layer failed, the size and position of the area of interest and its
+// locator map. Failed sections say so instead of showing empty tables. The
+// data sources appendix comes last.
+func reportContent(aoi Geom, units areaUnit, sections []ReportSection, results []layerResult, sources []LayerSource) []SectionContent {
+	var contents []SectionContent
+	if summary, ok := executiveSummary(sections, results); ok {
+		contents = append(contents, summary)
+	}
+	if availability, ok := dataAvailability(sections, results); ok {
+		contents = append(contents, availability)
+	}
+	if geometry, ok := geometrySummary(aoi, units); ok {
+		contents = append(contents, geometry)
+	}
+	if location, ok := locatorMap(aoi, mapLayers(sections, results)); ok {
+		contents = append(contents, location)
+	}
+	for i, section := range sections {
+		if results[i].Status != LayerOK {
+			contents = append(contents, SectionContent{
+				Title: section.Title(),
+				Blurb: "The data for this section could not be retrieved. See Data availability at the start of the report.",
+			})
+			continue
+		}
+		content := section.Content()
+		if results[i].Truncated {
+			content.Notes = append(content.Notes, "This area has more "+section.Title()+" features than the report limit of "+strconv.Itoa(maxLayerFeatures)+". Only the first "+strconv.Itoa(maxLayerFeatures)+" are included above.")
+		}
+		contents = append(contents, content)
+	}
+	return append(contents, provenanceAppendix(aoi, sources))
+}
+
+// dataAvailability lists the sections whose layers could not be retrieved, so a
+// missing layer is not mistaken for an area with no features. ok is false
+// when every layer came back.
+func dataAvailability(sections []ReportSection, results []layerResult) (content SectionContent, ok bool) {
+	var rows [][]string
+	for i, section := range sections {
+		if results[i].Status != LayerOK {
+			rows = append(rows, []string{section.Title(), results[i].Status.String()})
+		}
+	}
+	if len(rows) == 0 {
+		return content, false
+	}
+	return SectionContent{
+		Title:  "Data availability",
+		Blurb:  "The following sections could not be retrieved from the NMWRAP map service when this report was generated. They are left out of the report and should not be read as having no features in this area.",
+		Tables: []ContentTable{{Widths: []float64{95, 95}, Headers: []string{"Section", "Problem"}, Rows: rows}},
+	}, true
+}
+
+// htmlReport is the data handed to ReportTmpl.
+type htmlReport struct {
+	Report
+	Logo  template.URL
+	Theme htmlTheme
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"datauri": func(png []byte) template.URL {
+		return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	},
+	"colwidth": func(width float64, widths []float64) template.CSS {
+		total := 0.0
+		for _, w := range widths {
+			total += w
+		}
+		return template.CSS("width: " + strconv.FormatFloat(100*width/total, 'f', 1, 64) + "%")
+	},
+	"cellalign": func(table ContentTable, i int) template.CSS {
+		return template.CSS("text-align: " + map[string]string{"L": "left", "C": "center", "R": "right"}[table.align(i)])
+	},
+	"pixels": func(mm float64) int {
+		return int(mm * 4)
+	},
+}).Parse(ReportTmpl))
+
+// writeHTMLReport writes the report as a single HTML file with the styles,
+// logo and charts inlined so it can be mailed or hosted as is.
+func writeHTMLReport(report Report, path string) error {
+	page := htmlReport{Report: report, Theme: reportTheme.html()}
+	if logo, err := ioutil.ReadFile(reportTheme.LogoPath); err == nil {
+		page.Logo = template.URL("data:" + http.DetectContentType(logo) + ";base64," + base64.StdEncoding.EncodeToString(logo))
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return reportTemplate.Execute(out, page)
+}
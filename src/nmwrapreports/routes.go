@@ -93,6 +93,18 @@ var routes = Routes{
 		"/reportupload",
 		GetReportFromUpload,
 	},
+	Route{
+		"ReportJob",
+		"GET",
+		"/reportjob/{id}",
+		ReportJob,
+	},
+	Route{
+		"GetBatchReport",
+		"GET",
+		"/reportjob/{id}/download",
+		GetBatchReport,
+	},
 	Route{
 		"ExtractFromUpload",
 		"POST",
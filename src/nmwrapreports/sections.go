@@ -0,0 +1,690 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/wcharczuk/go-chart"
+	"github.com/wcharczuk/go-chart/drawing"
+)
+
+// ReportSection is one part of a report that is backed by a single layer of
+// the NMWRAP MapServer. ReportGen queries the layer described by Query, hands
+// the response to Decode and then asks the section for its Content, which is
+// written out in whichever format the user asked for.
+type ReportSection interface {
+	// Title is the section heading.
+	Title() string
+	// Query describes the layer query the section needs.
+	Query() SectionQuery
+	// Decode unpacks the ArcGIS query response for the section.
+	Decode(body []byte) error
+	// Content is what the section shows in the report.
+	Content() SectionContent
+	// Records is every decoded attribute, for the tabular exports.
+	Records() SectionRecords
+}
+
+// SectionQuery is the layer query spec for a ReportSection.
+type SectionQuery struct {
+	Service        string
+	LayerID        int
+	OutFields      string
+	ReturnGeometry bool
+	Distance       float64 // buffer around the area of interest, in Web Mercator metres
+}
+
+// SectionFactory returns a new, empty ReportSection for one catalog layer of a single report.
+type SectionFactory func(layer CatalogLayer) ReportSection
+
+type registeredSection struct {
+	Name string
+	New  SectionFactory
+}
+
+// sectionRegistry holds the sections that know how to render a particular layer.
+var sectionRegistry []registeredSection
+
+// RegisterSection makes a section available to the layer catalog under name.
+// Adding a new NMWRAP layer to the report only needs a ReportSection, a call
+// to this from an init func and a catalog entry naming it.
+func RegisterSection(name string, factory SectionFactory) {
+	for _, s := range sectionRegistry {
+		if s.Name == name {
+			log.Fatal("Report section registered twice: ", name)
+		}
+	}
+	sectionRegistry = append(sectionRegistry, registeredSection{Name: name, New: factory})
+}
+
+// ReportSections creates a fresh section for every layer in the catalog, in
+// report order. Layers without a registered section get a generic table.
+func ReportSections() []ReportSection {
+	sections := make([]ReportSection, 0, len(layerCatalog))
+	for _, layer := range layerCatalog {
+		var section ReportSection
+		for _, s := range sectionRegistry {
+			if s.Name == layer.Section {
+				section = s.New(layer)
+			}
+		}
+		if section == nil {
+			section = &genericSection{layerSection: layerSection{layer}}
+		}
+		sections = append(sections, section)
+	}
+	return sections
+}
+
+func init() {
+	RegisterSection("FireStations", func(layer CatalogLayer) ReportSection {
+		return &fireStationsSection{layerSection: layerSection{layer}}
+	})
+	RegisterSection("CommunitiesAtRisk", func(layer CatalogLayer) ReportSection {
+		return &communitiesAtRiskSection{layerSection: layerSection{layer}}
+	})
+	RegisterSection("IncorporatedCityBoundaries", func(layer CatalogLayer) ReportSection {
+		return &cityBoundariesSection{layerSection: layerSection{layer}}
+	})
+	RegisterSection("VegetationTreatments", func(layer CatalogLayer) ReportSection {
+		return &vegetationTreatmentsSection{layerSection: layerSection{layer}}
+	})
+	RegisterSection("WatershedsHUC8", func(layer CatalogLayer) ReportSection {
+		return &watershedsHUC8Section{layerSection: layerSection{layer}}
+	})
+	RegisterSection("County", func(layer CatalogLayer) ReportSection {
+		return &countySection{layerSection: layerSection{layer}}
+	})
+}
+
+//  ___ ___ ___ _____ ___ ___  _  _ ___
+// / __| __/ __|_   _|_ _/ _ \| \| / __|
+// \__ \ _| (__  | |  | | (_) | .` \__ \
+// |___/___\___| |_| |___\___/|_|\_|___/
+
+// layerSection carries the catalog layer a section was created for.
+type layerSection struct {
+	layer CatalogLayer
+}
+
+func (s layerSection) Title() string {
+	return s.layer.Title
+}
+
+func (s layerSection) Query() SectionQuery {
+	return s.layer.Query()
+}
+
+// genericSection renders the configured columns of a layer that has no section of its own.
+type genericSection struct {
+	layerSection
+	data struct {
+		Features []struct {
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"features"`
+		Fields []struct {
+			Alias string `json:"alias"`
+			Name  string `json:"name"`
+		} `json:"fields"`
+	}
+}
+
+func (s *genericSection) Decode(body []byte) error {
+	return json.Unmarshal(body, &s.data)
+}
+
+func (s *genericSection) Records() SectionRecords {
+	records := SectionRecords{Name: s.layer.Title}
+	for _, field := range s.data.Fields {
+		if field.Alias != "" {
+			records.Headers = append(records.Headers, field.Alias)
+		} else {
+			records.Headers = append(records.Headers, field.Name)
+		}
+	}
+	for _, element := range s.data.Features {
+		row := make([]string, len(s.data.Fields))
+		for i, field := range s.data.Fields {
+			row[i] = attributeString(element.Attributes[field.Name])
+		}
+		records.Rows = append(records.Rows, row)
+	}
+	return records
+}
+
+func (s *genericSection) Content() SectionContent {
+	content := SectionContent{
+		Title: s.layer.Title,
+		Blurb: s.narrative(NarrativeData{Count: len(s.data.Features), Features: s.data.Features}),
+	}
+	if len(s.layer.Columns) == 0 {
+		return content
+	}
+	widths := make([]float64, len(s.layer.Columns))
+	headers := make([]string, len(s.layer.Columns))
+	for i := range s.layer.Columns {
+		widths[i] = 190.0 / float64(len(s.layer.Columns))
+		headers[i] = s.layer.Label(i)
+	}
+	var rows [][]string
+	for _, element := range s.data.Features {
+		row := make([]string, len(s.layer.Columns))
+		for i, column := range s.layer.Columns {
+			row[i] = attributeString(element.Attributes[column])
+		}
+		rows = append(rows, row)
+	}
+	content.Tables = []ContentTable{{Widths: widths, Headers: headers, Rows: rows}}
+	return content
+}
+
+// attributeString formats a loosely typed ArcGIS attribute value for display.
+func attributeString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return fmt.Sprint(v)
+}
+
+type fireStationsSection struct {
+	layerSection
+	data      FireStations
+	aoi       Geom
+	units     areaUnit
+	distances []stationDistance
+}
+
+func (s *fireStationsSection) Decode(body []byte) error {
+	if err := json.Unmarshal(body, &s.data); err != nil {
+		return err
+	}
+	var err error
+	s.distances, err = stationDistances(s.aoi, body)
+	return err
+}
+
+func (s *fireStationsSection) SetArea(aoi Geom, units areaUnit) {
+	s.aoi = aoi
+	s.units = units
+}
+
+// Query looks for stations within the search radius of the area of interest,
+// not just inside it, and returns their locations so they can be measured.
+func (s *fireStationsSection) Query() SectionQuery {
+	query := s.layer.Query()
+	query.ReturnGeometry = true
+	if len(s.aoi.Rings) > 0 {
+		query.Distance = searchDistance(s.aoi.Rings, stationSearch.Radius*mileMetres)
+	}
+	return query
+}
+
+func (s *fireStationsSection) MapStyle() MapStyle {
+	return MapStyle{Stroke: drawing.ColorWhite, Fill: drawing.Color{R: 200, G: 30, B: 30, A: 255}, Width: 3, Radius: 12}
+}
+
+// listed are the stations the report lists: every station inside the area
+// and the nearest within the search radius, up to stationSearch.Nearest in
+// all, nearest first.
+func (s *fireStationsSection) listed() []stationDistance {
+	radius := stationSearch.Radius * mileMetres
+	var listed []stationDistance
+	for _, distance := range s.distances {
+		if distance.Boundary > radius {
+			break
+		}
+		if distance.Boundary == 0 || len(listed) < stationSearch.Nearest {
+			listed = append(listed, distance)
+		}
+	}
+	return listed
+}
+
+// distanceHeaders are the export columns the distances of the listed
+// stations go in.
+func (s *fireStationsSection) distanceHeaders() []string {
+	return []string{"From area edge (" + s.units.Length.Label + ")", "From centroid (" + s.units.Length.Label + ")"}
+}
+
+// Records exports the listed stations, with their distances, rather than
+// everything the search around the area found.
+func (s *fireStationsSection) Records() SectionRecords {
+	listed := s.data
+	listed.Features = nil
+	for _, distance := range s.listed() {
+		listed.Features = append(listed.Features, s.data.Features[distance.Feature])
+	}
+	records := aliasedRecords(s.layer.Title, listed)
+	records.Headers = append(records.Headers, s.distanceHeaders()...)
+	for i, distance := range s.listed() {
+		records.Rows[i] = append(records.Rows[i], formatNumber(distance.Boundary/s.units.Length.Metres, 2), formatNumber(distance.Centroid/s.units.Length.Metres, 2))
+	}
+	return records
+}
+
+// ExportBody keeps the listed stations of the query response, with their
+// distances added to their attributes.
+func (s *fireStationsSection) ExportBody(body []byte) ([]byte, error) {
+	var response featurePage
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	headers := s.distanceHeaders()
+	var features []json.RawMessage
+	for _, distance := range s.listed() {
+		var feature map[string]json.RawMessage
+		if err := json.Unmarshal(response.Features[distance.Feature], &feature); err != nil {
+			return nil, err
+		}
+		var attributes map[string]interface{}
+		if err := json.Unmarshal(feature["attributes"], &attributes); err != nil {
+			return nil, err
+		}
+		attributes[headers[0]] = math.Round(distance.Boundary/s.units.Length.Metres*100) / 100
+		attributes[headers[1]] = math.Round(distance.Centroid/s.units.Length.Metres*100) / 100
+		var err error
+		if feature["attributes"], err = json.Marshal(attributes); err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(feature)
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, raw)
+	}
+	return mergePages(body, features, false)
+}
+
+// Content lists the stations inside the area and the nearest around it.
+func (s *fireStationsSection) Content() SectionContent {
+	radius := stationSearch.Radius * mileMetres
+	threshold := stationSearch.Threshold * mileMetres
+	var rows [][]string
+	var names []string
+	data := NarrativeData{
+		Features:  s.data.Features,
+		Radius:    s.units.length(radius),
+		Threshold: s.units.length(threshold),
+	}
+	for _, distance := range s.distances {
+		if distance.Boundary > radius {
+			break
+		}
+		element := s.data.Features[distance.Feature]
+		if distance.Boundary == 0 {
+			data.Count++
+			names = append(names, element.Attributes.INSTNAME)
+		}
+		if distance.Boundary <= threshold {
+			data.WithinThreshold++
+		}
+		if data.Nearby == 0 {
+			data.Nearest = element.Attributes.INSTNAME
+			data.NearestDistance = s.units.length(distance.Boundary)
+		}
+		data.Nearby++
+	}
+	for _, distance := range s.listed() {
+		element := s.data.Features[distance.Feature]
+		rows = append(rows, []string{
+			element.Attributes.INSTNAME,
+			element.Attributes.ADDRESS,
+			element.Attributes.CITY,
+			s.units.length(distance.Boundary),
+			s.units.length(distance.Centroid),
+		})
+	}
+	data.Names = distinct(names)
+	content := SectionContent{
+		Title: s.layer.Title,
+		Blurb: s.narrative(data),
+		Tables: []ContentTable{{
+			Widths:  []float64{60, 45, 29, 28, 28},
+			Headers: []string{"Name", "Address", "City", "From area edge", "From centroid"},
+			Rows:    rows,
+			Align:   []string{"L", "L", "L", "R", "R"},
+		}},
+	}
+	if data.WithinThreshold == 0 {
+		content.Notes = append(content.Notes, "No fire station is within "+data.Threshold+" of this area.")
+	}
+	return content
+}
+
+type communitiesAtRiskSection struct {
+	layerSection
+	data    CommunitesatRisk
+	CARLow  int
+	CARMed  int
+	CARHigh int
+}
+
+func (s *communitiesAtRiskSection) Decode(body []byte) error {
+	if err := json.Unmarshal(body, &s.data); err != nil {
+		return err
+	}
+	for _, element := range s.data.Features {
+		switch element.Attributes.Rate2016 {
+		case "L":
+			s.CARLow++
+		case "M":
+			s.CARMed++
+		case "H":
+			s.CARHigh++
+		}
+	}
+	return nil
+}
+
+func (s *communitiesAtRiskSection) Query() SectionQuery {
+	query := s.layer.Query()
+	query.ReturnGeometry = mapFeatures
+	return query
+}
+
+func (s *communitiesAtRiskSection) MapStyle() MapStyle {
+	return MapStyle{Stroke: drawing.Color{R: 110, G: 50, B: 150, A: 255}, Fill: drawing.Color{R: 110, G: 50, B: 150, A: 70}, Width: 3, Radius: 10}
+}
+
+func (s *communitiesAtRiskSection) AddRiskInputs(inputs *RiskInputs) {
+	inputs.HasCommunities = true
+	inputs.Communities += len(s.data.Features)
+	inputs.CommunitiesHigh += s.CARHigh
+	inputs.CommunitiesMedium += s.CARMed
+}
+
+func (s *communitiesAtRiskSection) Records() SectionRecords {
+	return aliasedRecords(s.layer.Title, s.data)
+}
+
+// riskLabel turns the single letter Rate_2016 code into something readable.
+func riskLabel(rate string) string {
+	switch rate {
+	case "L":
+		return "Low Risk"
+	case "M":
+		return "Medium Risk"
+	case "H":
+		return "High Risk"
+	}
+	return ""
+}
+
+func (s *communitiesAtRiskSection) Content() SectionContent {
+	var rows [][]string
+	var names []string
+	for _, element := range s.data.Features {
+		rows = append(rows, []string{element.Attributes.NAME, element.Attributes.NAME1, riskLabel(element.Attributes.Rate2016)})
+		names = append(names, element.Attributes.NAME)
+	}
+	names = distinct(names)
+	content := SectionContent{
+		Title:  s.layer.Title,
+		Blurb:  s.narrative(NarrativeData{Count: len(s.data.Features), Names: names, Features: s.data.Features, CARHigh: s.CARHigh, CARMed: s.CARMed, CARLow: s.CARLow}),
+		Tables: []ContentTable{{Widths: []float64{64, 63, 63}, Headers: []string{"Name", "County", "Rate"}, Rows: rows}},
+	}
+
+	if s.CARLow+s.CARMed+s.CARHigh == 0 {
+		return content
+	}
+	pie := chart.PieChart{
+		Title:  "Communities At Risk",
+		Font:   reportTheme.chartFont(),
+		Width:  512,
+		Height: 512,
+		Values: []chart.Value{
+			{Value: float64(s.CARHigh), Label: strconv.Itoa(s.CARHigh) + " High Risk"},
+			{Value: float64(s.CARMed), Label: strconv.Itoa(s.CARMed) + " Medium Risk"},
+			{Value: float64(s.CARLow), Label: strconv.Itoa(s.CARLow) + " Low Risk"},
+		},
+	}
+
+	buffer := bytes.NewBuffer([]byte{})
+	err := pie.Render(chart.PNG, buffer)
+	if err != nil {
+		log.Println("Rendering pie chart: ", err)
+		return content
+	}
+	content.Charts = []ContentChart{{Name: "piechart", PNG: buffer.Bytes(), Width: 128, Height: 128}}
+	return content
+}
+
+type cityBoundariesSection struct {
+	layerSection
+	data     IncorporatedCityBoundaries
+	overlaps []Overlap
+	units    areaUnit
+}
+
+func (s *cityBoundariesSection) Decode(body []byte) error {
+	return json.Unmarshal(body, &s.data)
+}
+
+// Query returns geometry so the overlap with the area of interest can be worked out.
+func (s *cityBoundariesSection) Query() SectionQuery {
+	query := s.layer.Query()
+	query.ReturnGeometry = true
+	return query
+}
+
+func (s *cityBoundariesSection) SetOverlaps(overlaps []Overlap, units areaUnit) {
+	s.overlaps = overlaps
+	s.units = units
+}
+
+func (s *cityBoundariesSection) Records() SectionRecords {
+	return aliasedRecords(s.layer.Title, s.data)
+}
+
+func (s *cityBoundariesSection) Content() SectionContent {
+	var rows [][]string
+	var names []string
+	for i, element := range s.data.Features {
+		inside, percent := overlapCells(s.overlaps, s.units, i)
+		rows = append(rows, []string{element.Attributes.NAME10, inside, percent})
+		names = append(names, element.Attributes.NAME10)
+	}
+	tables := []ContentTable{{Widths: []float64{110, 40, 40}, Headers: []string{"Name", "Area inside", "Share of area"}, Rows: rows, Align: []string{"L", "R", "R"}}}
+	if geometry, ok := featureGeometryTable(names, s.overlaps, s.units); ok {
+		tables = append(tables, geometry)
+	}
+	names = distinct(names)
+	return SectionContent{
+		Title:  s.layer.Title,
+		Blurb:  s.narrative(NarrativeData{Count: len(s.data.Features), Names: names, Features: s.data.Features}),
+		Tables: tables,
+	}
+}
+
+type vegetationTreatmentsSection struct {
+	layerSection
+	data  VegetationTreatments
+	units areaUnit
+}
+
+// SetArea only keeps the units, which treated areas are shown in.
+func (s *vegetationTreatmentsSection) SetArea(aoi Geom, units areaUnit) {
+	s.units = units
+}
+
+func (s *vegetationTreatmentsSection) Decode(body []byte) error {
+	return json.Unmarshal(body, &s.data)
+}
+
+func (s *vegetationTreatmentsSection) Query() SectionQuery {
+	query := s.layer.Query()
+	query.ReturnGeometry = mapFeatures
+	return query
+}
+
+func (s *vegetationTreatmentsSection) MapStyle() MapStyle {
+	return MapStyle{Stroke: drawing.Color{R: 40, G: 130, B: 60, A: 255}, Fill: drawing.Color{R: 40, G: 130, B: 60, A: 90}, Width: 2, Radius: 8}
+}
+
+func (s *vegetationTreatmentsSection) Records() SectionRecords {
+	return aliasedRecords(s.layer.Title, s.data)
+}
+
+func (s *vegetationTreatmentsSection) Content() SectionContent {
+	var rows [][]string
+	var names []string
+	for _, element := range s.data.Features {
+		rows = append(rows, []string{element.Attributes.Description, element.Attributes.NameProj, element.Attributes.Partners})
+		names = append(names, element.Attributes.NameProj)
+	}
+	names = distinct(names)
+	acres := 0.0
+	for _, element := range s.data.Features {
+		acres += element.Attributes.AcreUS
+	}
+	tables, charts := s.analytics()
+	return SectionContent{
+		Title:  s.layer.Title,
+		Blurb:  s.narrative(NarrativeData{Count: len(s.data.Features), Names: names, Features: s.data.Features, Acres: acres, Area: s.units.area(acres * acreMetres)}),
+		Tables: append([]ContentTable{{Widths: []float64{64, 63, 63}, Headers: []string{"Description", "NameProj", "Partners"}, Rows: rows}}, tables...),
+		Charts: charts,
+	}
+}
+
+type watershedsHUC8Section struct {
+	layerSection
+	data     WatershedsHUC8
+	overlaps []Overlap
+	units    areaUnit
+}
+
+func (s *watershedsHUC8Section) Decode(body []byte) error {
+	return json.Unmarshal(body, &s.data)
+}
+
+func (s *watershedsHUC8Section) AddRiskInputs(inputs *RiskInputs) {
+	inputs.HasWatersheds = true
+	for _, element := range s.data.Features {
+		inputs.Watersheds++
+		if element.Attributes.FinalRankVeryHighHigh > 0 {
+			inputs.WatershedsHighRank++
+		}
+		if element.Attributes.FireRank > inputs.FireRank {
+			inputs.FireRank = element.Attributes.FireRank
+		}
+		inputs.WUIStructures += element.Attributes.TotalStructuresInWUI
+		inputs.Wildfires += element.Attributes.WildfireCount2006_2016
+	}
+}
+
+// Query returns geometry so the overlap with the area of interest can be worked out.
+func (s *watershedsHUC8Section) Query() SectionQuery {
+	query := s.layer.Query()
+	query.ReturnGeometry = true
+	return query
+}
+
+func (s *watershedsHUC8Section) SetOverlaps(overlaps []Overlap, units areaUnit) {
+	s.overlaps = overlaps
+	s.units = units
+}
+
+func (s *watershedsHUC8Section) Records() SectionRecords {
+	return aliasedRecords(s.layer.Title, s.data)
+}
+
+func (s *watershedsHUC8Section) Content() SectionContent {
+	var rows [][]string
+	var names []string
+	for i, element := range s.data.Features {
+		_, percent := overlapCells(s.overlaps, s.units, i)
+		rows = append(rows, []string{
+			element.Attributes.NAME,
+			strconv.Itoa(element.Attributes.TNCRanking),
+			element.Attributes.STATES,
+			percent,
+			prorated(element.Attributes.PopulationWithinHUC8, s.overlaps, i),
+			prorated(element.Attributes.TotalStructuresInWUI, s.overlaps, i),
+		})
+		names = append(names, element.Attributes.NAME)
+	}
+	tables := []ContentTable{{
+		Widths:  []float64{50, 25, 20, 25, 35, 35},
+		Headers: []string{"Name", "TNC Ranking", "State", "Share of area", "Population in area", "WUI structures in area"},
+		Rows:    rows,
+		Align:   []string{"L", "R", "L", "R", "R", "R"},
+	}}
+	if geometry, ok := featureGeometryTable(names, s.overlaps, s.units); ok {
+		tables = append(tables, geometry)
+	}
+	names = distinct(names)
+	var notes []string
+	if len(s.overlaps) > 0 {
+		notes = append(notes, "Population and WUI structures in the area are pro-rated from each watershed by the share of its area inside the area of interest, assuming they are spread evenly across the watershed.")
+	}
+	return SectionContent{
+		Title:  s.layer.Title,
+		Blurb:  s.narrative(NarrativeData{Count: len(s.data.Features), Names: names, Features: s.data.Features, Prorated: len(s.overlaps) > 0}),
+		Tables: append(tables, s.profiles()...),
+		Charts: s.charts(),
+		Notes:  notes,
+	}
+}
+
+type countySection struct {
+	layerSection
+	data     County
+	overlaps []Overlap
+	units    areaUnit
+}
+
+func (s *countySection) Decode(body []byte) error {
+	return json.Unmarshal(body, &s.data)
+}
+
+// Query always returns geometry, as the county outlines are the base of the
+// locator map and are needed for the overlap with the area of interest.
+func (s *countySection) Query() SectionQuery {
+	query := s.layer.Query()
+	query.ReturnGeometry = true
+	return query
+}
+
+func (s *countySection) MapStyle() MapStyle {
+	return MapStyle{Stroke: drawing.Color{R: 120, G: 120, B: 120, A: 255}, Fill: drawing.ColorTransparent, Width: 3, Radius: 6, Context: true}
+}
+
+func (s *countySection) SetOverlaps(overlaps []Overlap, units areaUnit) {
+	s.overlaps = overlaps
+	s.units = units
+}
+
+func (s *countySection) Records() SectionRecords {
+	return aliasedRecords(s.layer.Title, s.data)
+}
+
+func (s *countySection) Content() SectionContent {
+	var rows [][]string
+	var names []string
+	for i, element := range s.data.Features {
+		inside, percent := overlapCells(s.overlaps, s.units, i)
+		rows = append(rows, []string{element.Attributes.NAMELSAD, inside, percent})
+		names = append(names, element.Attributes.NAMELSAD)
+	}
+	tables := []ContentTable{{Widths: []float64{110, 40, 40}, Headers: []string{"County", "Area inside", "Share of area"}, Rows: rows, Align: []string{"L", "R", "R"}}}
+	if geometry, ok := featureGeometryTable(names, s.overlaps, s.units); ok {
+		tables = append(tables, geometry)
+	}
+	names = distinct(names)
+	return SectionContent{
+		Title:  s.layer.Title,
+		Blurb:  s.narrative(NarrativeData{Count: len(s.data.Features), Names: names, Features: s.data.Features}),
+		Tables: tables,
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+)
+
+// mileMetres is the length of a mile in metres.
+const mileMetres = 1609.344
+
+// StationSearch sets how far around the area of interest fire stations are
+// looked for, from the [Stations] table of the config file. Radius and
+// Threshold are in miles. The report lists the Nearest stations within Radius
+// and flags the area when none is within Threshold.
+type StationSearch struct {
+	Radius    float64
+	Threshold float64
+	Nearest   int
+}
+
+// stationSearch is the search every report uses.
+var stationSearch = defaultStationSearch()
+
+func defaultStationSearch() StationSearch {
+	return StationSearch{Radius: 25, Threshold: 5, Nearest: 5}
+}
+
+// LoadStationSearch uses the configured settings, and the default for any
+// that aren't set.
+func LoadStationSearch(config StationSearch) StationSearch {
+	search := defaultStationSearch()
+	if config.Radius > 0 {
+		search.Radius = config.Radius
+	}
+	if config.Threshold > 0 {
+		search.Threshold = config.Threshold
+	}
+	if config.Nearest > 0 {
+		search.Nearest = config.Nearest
+	}
+	return search
+}
+
+// proximitySection is a ReportSection that is given the area of interest and
+// the report units before its query is built, so it can search around the
+// area rather than only inside it, or show its own areas in those units.
+type proximitySection interface {
+	SetArea(aoi Geom, units areaUnit)
+}
+
+// setArea gives every proximity section the area of interest.
+func setArea(aoi Geom, units areaUnit, sections []ReportSection) {
+	for _, section := range sections {
+		if proximity, ok := section.(proximitySection); ok {
+			proximity.SetArea(aoi, units)
+		}
+	}
+}
+
+// searchDistance is the buffer, in Web Mercator metres, that takes in at
+// least ground metres around rings. Web Mercator stretches distances by
+// 1/cos of the latitude, so the stretch at the north edge of the buffer is
+// used.
+func searchDistance(rings [][][]float64, ground float64) float64 {
+	north := summarizeGeometry(rings).North + ground/earthRadius*180/math.Pi
+	return ground / math.Cos(math.Min(north, 85)*math.Pi/180)
+}
+
+// stationDistance is how far a station is from the area of interest.
+type stationDistance struct {
+	Feature  int     // index in the layer's features
+	Boundary float64 // metres from the nearest edge, 0 when inside
+	Centroid float64 // metres from the centroid
+}
+
+// stationDistances measures every station with a point geometry from the
+// area of interest, nearest first. An area without polygons is an error, as
+// there is nothing to measure from.
+func stationDistances(aoi Geom, body []byte) ([]stationDistance, error) {
+	if len(aoi.Rings) == 0 {
+		return nil, errors.New("The area of interest has no polygons to measure the stations from")
+	}
+	var features esriFeatureSet
+	if err := json.Unmarshal(body, &features); err != nil {
+		return nil, err
+	}
+	summary := summarizeGeometry(aoi.Rings)
+	centroid := []float64{summary.CentroidLon, summary.CentroidLat}
+	lonLat := ringsToLonLat(aoi.Rings)
+	var distances []stationDistance
+	for i, feature := range features.Features {
+		if feature.Geometry == nil || feature.Geometry.X == nil || feature.Geometry.Y == nil {
+			continue
+		}
+		lon, lat := mercatorToLonLat(*feature.Geometry.X, *feature.Geometry.Y)
+		station := []float64{lon, lat}
+		distances = append(distances, stationDistance{
+			Feature:  i,
+			Boundary: boundaryDistance(station, lonLat),
+			Centroid: greatCircle(station, centroid),
+		})
+	}
+	sort.SliceStable(distances, func(i, j int) bool {
+		if distances[i].Boundary != distances[j].Boundary {
+			return distances[i].Boundary < distances[j].Boundary
+		}
+		return distances[i].Centroid < distances[j].Centroid
+	})
+	return distances, nil
+}
+
+// boundaryDistance is the distance in metres from a longitude/latitude point
+// to the nearest edge of longitude/latitude rings, or 0 when the point is
+// inside them, and infinite when the rings have no edges. The rings are
+// flattened onto a plane true to scale at the point, which is close enough
+// over the distance of a station search.
+func boundaryDistance(pt []float64, rings [][][]float64) float64 {
+	scale := earthRadius * math.Pi / 180
+	squeeze := math.Cos(pt[1] * math.Pi / 180)
+	inside := false
+	nearest := math.Inf(1)
+	for _, ring := range rings {
+		flat := make([][]float64, len(ring))
+		for i, v := range ring {
+			flat[i] = []float64{(v[0] - pt[0]) * squeeze * scale, (v[1] - pt[1]) * scale}
+		}
+		if pointInRing(0, 0, flat) {
+			inside = !inside
+		}
+		for i := range flat {
+			nearest = math.Min(nearest, segmentDistance(flat[i], flat[(i+1)%len(flat)]))
+		}
+	}
+	if inside {
+		return 0
+	}
+	return nearest
+}
+
+// segmentDistance is the planar distance from the origin to the segment a-b.
+func segmentDistance(a, b []float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, -(a[0]*dx+a[1]*dy)/length))
+	}
+	return math.Hypot(a[0]+t*dx, a[1]+t*dy)
+}
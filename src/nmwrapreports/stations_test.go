@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// stationsBody is a query response with a station at each longitude/latitude
+// point, and one without a geometry at the end.
+func stationsBody(t *testing.T, points ...[2]float64) []byte {
+	var features []map[string]interface{}
+	for i, pt := range points {
+		x, y := lonLatToMercator(pt[0], pt[1])
+		features = append(features, map[string]interface{}{
+			"attributes": map[string]interface{}{"OBJECTID": i},
+			"geometry":   map[string]float64{"x": x, "y": y},
+		})
+	}
+	features = append(features, map[string]interface{}{"attributes": map[string]interface{}{"OBJECTID": len(points)}})
+	body, err := json.Marshal(map[string]interface{}{"features": features})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestStationDistances(t *testing.T) {
+	square := Geom{Rings: [][][]float64{box(-106, 35, -105, 36)}}
+	body := stationsBody(t,
+		[2]float64{-104.9, 35.5},  // 0.1° east of the east edge
+		[2]float64{-105.5, 35.5},  // in the middle
+		[2]float64{-105.5, 36.02}, // 0.02° north of the north edge
+		[2]float64{-105.9, 35.1},  // inside, near a corner
+		[2]float64{-107, 34},      // off the south west corner
+	)
+	distances, err := stationDistances(square, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		feature  int
+		boundary float64
+	}{
+		{1, 0},
+		{3, 0},
+		{2, greatCircle([]float64{-105.5, 36}, []float64{-105.5, 36.02})},
+		{0, greatCircle([]float64{-105, 35.5}, []float64{-104.9, 35.5})},
+		{4, greatCircle([]float64{-106, 35}, []float64{-107, 34})},
+	}
+	if len(distances) != len(tests) {
+		t.Fatalf("%d stations measured, want %d", len(distances), len(tests))
+	}
+	for i, test := range tests {
+		distance := distances[i]
+		if distance.Feature != test.feature {
+			t.Errorf("station %d is feature %d, want %d", i, distance.Feature, test.feature)
+			continue
+		}
+		if !closeTo(distance.Boundary, test.boundary, 0.01) {
+			t.Errorf("feature %d: %f m from the edge, want %f", test.feature, distance.Boundary, test.boundary)
+		}
+	}
+	//The middle station is nearer the centroid than the one near the corner, so it comes first.
+	if distances[0].Centroid >= distances[1].Centroid {
+		t.Errorf("inside stations not ordered by distance from the centroid: %f, %f", distances[0].Centroid, distances[1].Centroid)
+	}
+}
+
+func TestStationDistancesWithoutArea(t *testing.T) {
+	if _, err := stationDistances(Geom{}, stationsBody(t, [2]float64{-105.5, 35.5})); err == nil {
+		t.Error("stations measured from an area without polygons")
+	}
+}
+
+func TestSearchDistance(t *testing.T) {
+	tests := []struct {
+		name   string
+		rings  [][][]float64
+		ground float64
+	}{
+		{"New Mexico", [][][]float64{box(-106, 35, -105, 36)}, 25 * mileMetres},
+		{"equator", [][][]float64{box(10, -1, 11, 0)}, 10000},
+		{"far north", [][][]float64{box(20, 84, 21, 84.5)}, 100000},
+	}
+	for _, test := range tests {
+		distance := searchDistance(test.rings, test.ground)
+		//Going north from the north edge by the buffer must cover at least ground metres.
+		north := summarizeGeometry(test.rings).North
+		_, edge := lonLatToMercator(0, north)
+		_, lat := mercatorToLonLat(0, edge+distance)
+		if covered := greatCircle([]float64{0, north}, []float64{0, lat}); covered < test.ground*(1-1e-9) {
+			t.Errorf("%s: buffer of %f covers %f m north, want at least %f", test.name, distance, covered, test.ground)
+		}
+		if distance < test.ground || distance > test.ground/math.Cos(85*math.Pi/180) {
+			t.Errorf("%s: buffer %f out of range for %f m", test.name, distance, test.ground)
+		}
+	}
+}
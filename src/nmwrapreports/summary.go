@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/wcharczuk/go-chart"
+	"github.com/wcharczuk/go-chart/drawing"
+)
+
+// RiskWeights sets how the composite risk score is made up, from the
+// [RiskScore] table of the config file. The score is the weighted average of
+// four factors, each scaled from 0 to 1:
+//
+//	Communities  the 2016 risk rating of the communities at risk in the area:
+//	             High counts 1, Medium 0.5 and Low 0, averaged over them all.
+//	Watersheds   the share of intersecting HUC8 watersheds in the Very High or
+//	             High final rank, averaged with the highest Fire_Rank among
+//	             them divided by FireRankCeiling.
+//	WUI          structures in the wildland-urban interface of the intersecting
+//	             watersheds, divided by WUICeiling.
+//	Wildfires    wildfires in the intersecting watersheds from 2006 to 2016,
+//	             divided by WildfireCeiling.
+//
+// Factors are capped at 1 and multiplied by 100, so the score runs from 0 to
+// 100. A factor whose layer could not be retrieved is left out and the
+// remaining weights are used.
+type RiskWeights struct {
+	Communities     float64
+	Watersheds      float64
+	WUI             float64
+	Wildfires       float64
+	FireRankCeiling float64
+	WUICeiling      float64
+	WildfireCeiling float64
+}
+
+// riskWeights are the weights every report is scored with.
+var riskWeights = defaultRiskWeights()
+
+func defaultRiskWeights() RiskWeights {
+	return RiskWeights{
+		Communities:     30,
+		Watersheds:      25,
+		WUI:             25,
+		Wildfires:       20,
+		FireRankCeiling: 10,
+		WUICeiling:      5000,
+		WildfireCeiling: 100,
+	}
+}
+
+// LoadRiskWeights uses the configured weights if any are set, and the default
+// for any ceiling that isn't.
+func LoadRiskWeights(config RiskWeights) RiskWeights {
+	weights := defaultRiskWeights()
+	if config.Communities+config.Watersheds+config.WUI+config.Wildfires > 0 {
+		weights.Communities = config.Communities
+		weights.Watersheds = config.Watersheds
+		weights.WUI = config.WUI
+		weights.Wildfires = config.Wildfires
+	}
+	if config.FireRankCeiling > 0 {
+		weights.FireRankCeiling = config.FireRankCeiling
+	}
+	if config.WUICeiling > 0 {
+		weights.WUICeiling = config.WUICeiling
+	}
+	if config.WildfireCeiling > 0 {
+		weights.WildfireCeiling = config.WildfireCeiling
+	}
+	return weights
+}
+
+// RiskInputs are the figures the risk score is worked out from. Sections
+// that carry them fill them in through riskSection.
+type RiskInputs struct {
+	HasCommunities    bool
+	Communities       int
+	CommunitiesHigh   int
+	CommunitiesMedium int
+
+	HasWatersheds      bool
+	Watersheds         int
+	WatershedsHighRank int
+	FireRank           int
+	WUIStructures      int
+	Wildfires          int
+}
+
+// riskSection is a ReportSection that contributes to the risk score.
+type riskSection interface {
+	AddRiskInputs(inputs *RiskInputs)
+}
+
+// riskFactor is one weighted part of the risk score.
+type riskFactor struct {
+	Name   string
+	Detail string
+	Weight float64
+	Value  float64 // 0 to 1
+}
+
+// riskInputs collects the inputs of every risk section whose layer came back.
+func riskInputs(sections []ReportSection, results []layerResult) RiskInputs {
+	var inputs RiskInputs
+	for i, section := range sections {
+		if risk, ok := section.(riskSection); ok && results[i].Status == LayerOK {
+			risk.AddRiskInputs(&inputs)
+		}
+	}
+	return inputs
+}
+
+// riskFactors scales the inputs into the factors that are available.
+func (w RiskWeights) riskFactors(inputs RiskInputs) []riskFactor {
+	var factors []riskFactor
+	if inputs.HasCommunities {
+		value := 0.0
+		if inputs.Communities > 0 {
+			value = (float64(inputs.CommunitiesHigh) + float64(inputs.CommunitiesMedium)/2) / float64(inputs.Communities)
+		}
+		factors = append(factors, riskFactor{
+			Name:   "Communities at risk",
+			Detail: strconv.Itoa(inputs.CommunitiesHigh) + " High and " + strconv.Itoa(inputs.CommunitiesMedium) + " Medium Risk of " + strconv.Itoa(inputs.Communities) + " communities",
+			Weight: w.Communities,
+			Value:  value,
+		})
+	}
+	if inputs.HasWatersheds {
+		value := math.Min(float64(inputs.FireRank)/w.FireRankCeiling, 1)
+		if inputs.Watersheds > 0 {
+			value = (value + float64(inputs.WatershedsHighRank)/float64(inputs.Watersheds)) / 2
+		}
+		factors = append(factors,
+			riskFactor{
+				Name:   "Watershed ranking",
+				Detail: strconv.Itoa(inputs.WatershedsHighRank) + " of " + strconv.Itoa(inputs.Watersheds) + " watersheds ranked Very High or High, highest fire rank " + strconv.Itoa(inputs.FireRank),
+				Weight: w.Watersheds,
+				Value:  value,
+			},
+			riskFactor{
+				Name:   "Wildland-urban interface",
+				Detail: formatNumber(float64(inputs.WUIStructures), 0) + " structures in the WUI of the intersecting watersheds",
+				Weight: w.WUI,
+				Value:  math.Min(float64(inputs.WUIStructures)/w.WUICeiling, 1),
+			},
+			riskFactor{
+				Name:   "Wildfire history",
+				Detail: formatNumber(float64(inputs.Wildfires), 0) + " wildfires from 2006 to 2016 in the intersecting watersheds",
+				Weight: w.Wildfires,
+				Value:  math.Min(float64(inputs.Wildfires)/w.WildfireCeiling, 1),
+			})
+	}
+	return factors
+}
+
+// riskScore is the weighted average of the factors, from 0 to 100.
+func riskScore(factors []riskFactor) float64 {
+	total, weights := 0.0, 0.0
+	for _, factor := range factors {
+		total += factor.Weight * factor.Value
+		weights += factor.Weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	return 100 * total / weights
+}
+
+// riskBand names a score and gives the colour it is drawn in.
+type riskBand struct {
+	Name  string
+	Upto  float64
+	Color drawing.Color
+}
+
+var riskBands = []riskBand{
+	{"Low", 25, drawing.Color{R: 76, G: 175, B: 80, A: 255}},
+	{"Moderate", 50, drawing.Color{R: 255, G: 193, B: 7, A: 255}},
+	{"High", 75, drawing.Color{R: 245, G: 124, B: 0, A: 255}},
+	{"Very High", 100, drawing.Color{R: 211, G: 47, B: 47, A: 255}},
+}
+
+// bandFor is the band a score falls in.
+func bandFor(score float64) riskBand {
+	for _, band := range riskBands {
+		if score < band.Upto {
+			return band
+		}
+	}
+	return riskBands[len(riskBands)-1]
+}
+
+// executiveSummary scores the area and lists what drives the score, largest
+// contribution first. ok is false when none of the risk layers came back.
+func executiveSummary(sections []ReportSection, results []layerResult) (content SectionContent, ok bool) {
+	factors := riskWeights.riskFactors(riskInputs(sections, results))
+	if len(factors) == 0 {
+		return content, false
+	}
+	score := riskScore(factors)
+	band := bandFor(score)
+	weights := 0.0
+	for _, factor := range factors {
+		weights += factor.Weight
+	}
+	sort.SliceStable(factors, func(i, j int) bool {
+		return factors[i].Weight*factors[i].Value > factors[j].Weight*factors[j].Value
+	})
+	var rows [][]string
+	for _, factor := range factors {
+		rows = append(rows, []string{
+			factor.Name,
+			factor.Detail,
+			strconv.FormatFloat(100*factor.Weight*factor.Value/weights, 'f', 1, 64),
+		})
+	}
+	content = SectionContent{
+		Title: "Executive summary",
+		Blurb: "This area has a composite wildfire risk score of " + strconv.FormatFloat(score, 'f', 0, 64) + " out of 100, which is " + band.Name + ". " +
+			"The score combines the risk rating of communities in the area with the ranking, wildland-urban interface and wildfire history of the HUC8 watersheds it touches. " +
+			"Watershed ranks, WUI structures and wildfires are for the whole of each watershed, not pro-rated to the part inside the area like the population and WUI structures in the watershed table. The factors below are listed by the points they add to the score.",
+		Tables: []ContentTable{{
+			Widths:  []float64{50, 115, 25},
+			Headers: []string{"Factor", "Detail", "Points"},
+			Rows:    rows,
+			Align:   []string{"L", "L", "R"},
+		}},
+	}
+	if len(factors) < 4 {
+		content.Notes = append(content.Notes, "Some of the layers the score uses could not be retrieved, so it is based on the remaining factors only.")
+	}
+	if gauge, err := riskGauge(score); err == nil {
+		content.Charts = []ContentChart{{Name: "riskgauge", PNG: gauge, Width: 100, Height: 60}}
+	}
+	return content, true
+}
+
+// riskGauge draws the score as a needle over a half dial coloured by band.
+func riskGauge(score float64) ([]byte, error) {
+	const width, height = 1000, 600
+	cx, cy, radius := width/2, 520, 440.0
+	r, err := chart.PNG(width, height)
+	if err != nil {
+		return nil, err
+	}
+	if font := reportTheme.chartFont(); font != nil {
+		r.SetFont(font)
+	} else if font, err := chart.GetDefaultFont(); err == nil {
+		r.SetFont(font)
+	}
+
+	from := 0.0
+	for _, band := range riskBands {
+		r.SetFillColor(band.Color)
+		r.SetStrokeColor(drawing.ColorWhite)
+		r.SetStrokeWidth(4)
+		r.MoveTo(cx, cy)
+		r.ArcTo(cx, cy, radius, radius, math.Pi+from/100*math.Pi, (band.Upto-from)/100*math.Pi)
+		r.LineTo(cx, cy)
+		r.Close()
+		r.FillStroke()
+		from = band.Upto
+	}
+	r.SetFillColor(drawing.ColorWhite)
+	r.SetStrokeColor(drawing.ColorWhite)
+	r.Circle(radius*0.55, cx, cy)
+	r.FillStroke()
+
+	angle := math.Pi + math.Min(math.Max(score, 0), 100)/100*math.Pi
+	r.SetStrokeColor(mapInk)
+	r.SetStrokeWidth(12)
+	r.MoveTo(cx, cy)
+	r.LineTo(cx+int(radius*0.9*math.Cos(angle)), cy+int(radius*0.9*math.Sin(angle)))
+	r.Stroke()
+	r.SetFillColor(mapInk)
+	r.Circle(20, cx, cy)
+	r.Fill()
+
+	label := strconv.FormatFloat(score, 'f', 0, 64)
+	r.SetFontColor(mapInk)
+	r.SetFontSize(64)
+	box := r.MeasureText(label)
+	r.Text(label, cx-box.Width()/2, cy-60)
+	r.SetFontSize(36)
+	box = r.MeasureText(bandFor(score).Name)
+	r.Text(bandFor(score).Name, cx-box.Width()/2, cy-150)
+
+	buffer := bytes.NewBuffer([]byte{})
+	if err := r.Save(buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRiskScore(t *testing.T) {
+	custom := LoadRiskWeights(RiskWeights{Communities: 1, Wildfires: 3})
+	tests := []struct {
+		name    string
+		weights RiskWeights
+		inputs  RiskInputs
+		factors int
+		want    float64
+	}{
+		{"nothing came back", defaultRiskWeights(), RiskInputs{}, 0, 0},
+		{"only High communities", defaultRiskWeights(), RiskInputs{HasCommunities: true, Communities: 2, CommunitiesHigh: 2}, 1, 100},
+		{"no communities in the area", defaultRiskWeights(), RiskInputs{HasCommunities: true}, 1, 0},
+		{"only watersheds, all zero", defaultRiskWeights(), RiskInputs{HasWatersheds: true}, 3, 0},
+		{"every factor half way", defaultRiskWeights(), RiskInputs{
+			HasCommunities: true, Communities: 4, CommunitiesHigh: 1, CommunitiesMedium: 2,
+			HasWatersheds: true, Watersheds: 2, WatershedsHighRank: 1, FireRank: 5, WUIStructures: 2500, Wildfires: 50,
+		}, 4, 50},
+		{"factors are capped at 1", defaultRiskWeights(), RiskInputs{
+			HasCommunities: true, Communities: 4, CommunitiesHigh: 1, CommunitiesMedium: 2,
+			HasWatersheds: true, Watersheds: 2, WatershedsHighRank: 1, FireRank: 5, WUIStructures: 2500, Wildfires: 200,
+		}, 4, (30*0.5 + 25*0.5 + 25*0.5 + 20*1) / 100 * 100},
+		{"configured weights", custom, RiskInputs{
+			HasCommunities: true, Communities: 1, CommunitiesHigh: 1,
+			HasWatersheds: true, Watersheds: 1, WatershedsHighRank: 1, FireRank: 10, WUIStructures: 5000, Wildfires: 50,
+		}, 4, (1*1 + 3*0.5) / 4 * 100},
+	}
+	for _, test := range tests {
+		factors := test.weights.riskFactors(test.inputs)
+		if len(factors) != test.factors {
+			t.Errorf("%s: %d factors, want %d", test.name, len(factors), test.factors)
+		}
+		if got := riskScore(factors); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: score %f, want %f", test.name, got, test.want)
+		}
+	}
+}
+
+// fakeRiskSection is a report section that adds fixed risk inputs.
+type fakeRiskSection struct {
+	ReportSection
+	add func(inputs *RiskInputs)
+}
+
+func (s fakeRiskSection) AddRiskInputs(inputs *RiskInputs) {
+	s.add(inputs)
+}
+
+func TestRiskInputsLeaveOutUnavailableLayers(t *testing.T) {
+	sections := []ReportSection{
+		fakeRiskSection{add: func(inputs *RiskInputs) {
+			inputs.HasCommunities = true
+			inputs.Communities = 2
+			inputs.CommunitiesHigh = 2
+		}},
+		fakeRiskSection{add: func(inputs *RiskInputs) {
+			inputs.HasWatersheds = true
+			inputs.Watersheds = 3
+		}},
+	}
+	tests := []struct {
+		name     string
+		statuses []LayerStatus
+		factors  int
+		want     float64
+	}{
+		{"both came back", []LayerStatus{LayerOK, LayerOK}, 4, 30},
+		{"watersheds unreachable", []LayerStatus{LayerOK, LayerUnreachable}, 1, 100},
+		{"watersheds service error", []LayerStatus{LayerOK, LayerServiceError}, 1, 100},
+		{"communities unreadable", []LayerStatus{LayerUnreadable, LayerOK}, 3, 0},
+	}
+	for _, test := range tests {
+		results := make([]layerResult, len(test.statuses))
+		for i, status := range test.statuses {
+			results[i].Status = status
+		}
+		factors := defaultRiskWeights().riskFactors(riskInputs(sections, results))
+		if len(factors) != test.factors {
+			t.Errorf("%s: %d factors, want %d", test.name, len(factors), test.factors)
+		}
+		if got := riskScore(factors); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: score %f, want %f", test.name, got, test.want)
+		}
+	}
+	if _, ok := executiveSummary(sections, []layerResult{{Status: LayerUnreachable}, {Status: LayerUnreachable}}); ok {
+		t.Error("executive summary made with no risk layers")
+	}
+}
+
+func TestBandFor(t *testing.T) {
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{0, "Low"},
+		{24.99, "Low"},
+		{25, "Moderate"},
+		{49.99, "Moderate"},
+		{50, "High"},
+		{74.99, "High"},
+		{75, "Very High"},
+		{100, "Very High"},
+	}
+	for _, test := range tests {
+		if got := bandFor(test.score).Name; got != test.want {
+			t.Errorf("bandFor(%v) = %s, want %s", test.score, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/base64"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Theme is the page setup and branding of the generated reports, read from
+// the [Theme] table of the config file. Anything left out keeps the look the
+// reports have always had.
+type Theme struct {
+	PageSize    string    // A3, A4, A5, Letter or Legal
+	Orientation string    // P for portrait or L for landscape
+	Margins     []float64 // left, top, right and bottom, in mm
+	Font        string    // one of the core PDF fonts: Helvetica, Times or Courier
+	FontFile    string    // a TrueType font to embed instead of Font
+	HeaderColor string    // #rrggbb; the PANTONE 145 CVC band when empty
+	LogoPath    string
+	FooterText  string
+
+	ttf  []byte
+	font *truetype.Font
+}
+
+// reportTheme is the theme every report is written with.
+var reportTheme = defaultTheme()
+
+// themeFontFamily is the name an embedded FontFile is registered under in the PDF.
+const themeFontFamily = "ThemeFont"
+
+// defaultTheme is the original A4 report layout.
+func defaultTheme() Theme {
+	return Theme{
+		PageSize:    "A4",
+		Orientation: "P",
+		Margins:     []float64{10, 10, 10, 20},
+		Font:        "Helvetica",
+		LogoPath:    "/var/lib/nmwrapreports/ziafire.png",
+	}
+}
+
+// LoadTheme fills in the defaults for whatever the config leaves out. Settings
+// that can't be used are logged and replaced with the default.
+func LoadTheme(config Theme) Theme {
+	theme := defaultTheme()
+	if config.PageSize != "" {
+		switch strings.ToLower(config.PageSize) {
+		case "a3", "a4", "a5", "letter", "legal":
+			theme.PageSize = config.PageSize
+		default:
+			log.Println("Unknown theme PageSize, using A4: ", config.PageSize)
+		}
+	}
+	if strings.HasPrefix(strings.ToUpper(config.Orientation), "L") {
+		theme.Orientation = "L"
+	}
+	if len(config.Margins) == 4 {
+		theme.Margins = config.Margins
+	} else if len(config.Margins) != 0 {
+		log.Println("Theme Margins needs left, top, right and bottom, using the default margins")
+	}
+	switch strings.ToLower(config.Font) {
+	case "":
+	case "helvetica", "arial", "times", "courier":
+		theme.Font = config.Font
+	default:
+		log.Println("Unknown theme Font, using Helvetica: ", config.Font)
+	}
+	if config.FontFile != "" {
+		ttf, err := ioutil.ReadFile(config.FontFile)
+		if err == nil {
+			theme.font, err = truetype.Parse(ttf)
+		}
+		if err != nil {
+			log.Println("Theme FontFile can't be used, using "+theme.Font+": ", err)
+		} else {
+			theme.FontFile = config.FontFile
+			theme.ttf = ttf
+		}
+	}
+	if config.HeaderColor != "" {
+		if _, _, _, ok := parseHexColor(config.HeaderColor); ok {
+			theme.HeaderColor = config.HeaderColor
+		} else {
+			log.Println("Theme HeaderColor should look like #c77d1a: ", config.HeaderColor)
+		}
+	}
+	if config.LogoPath != "" {
+		theme.LogoPath = config.LogoPath
+	}
+	theme.FooterText = config.FooterText
+	return theme
+}
+
+// parseHexColor reads a #rrggbb colour.
+func parseHexColor(hex string) (r, g, b int, ok bool) {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		return 0, 0, 0, false
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return int(rgb >> 16), int(rgb >> 8 & 0xff), int(rgb & 0xff), true
+}
+
+// fontFamily is the PDF font family the report text is set in.
+func (t Theme) fontFamily() string {
+	if t.FontFile != "" {
+		return themeFontFamily
+	}
+	return t.Font
+}
+
+// setFont switches the PDF to the theme font at size points.
+func (t Theme) setFont(pdf *gofpdf.Fpdf, size float64) {
+	pdf.SetFont(t.fontFamily(), "", size)
+}
+
+// setHeaderFill makes the header colour the PDF fill colour, for the title
+// band and table headings.
+func (t Theme) setHeaderFill(pdf *gofpdf.Fpdf) {
+	if r, g, b, ok := parseHexColor(t.HeaderColor); ok {
+		pdf.SetFillColor(r, g, b)
+		return
+	}
+	pdf.SetFillSpotColor("PANTONE 145 CVC", 90)
+}
+
+// newPDF starts a PDF with the theme's page setup and fonts.
+func (t Theme) newPDF() *gofpdf.Fpdf {
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		OrientationStr: t.Orientation,
+		UnitStr:        "mm",
+		SizeStr:        t.PageSize,
+	})
+	if t.ttf != nil {
+		pdf.AddUTF8FontFromBytes(themeFontFamily, "", t.ttf)
+	}
+	pdf.AddSpotColor("PANTONE 145 CVC", 0, 42, 100, 25)
+	pdf.SetMargins(t.Margins[0], t.Margins[1], t.Margins[2])
+	pdf.SetAutoPageBreak(true, t.Margins[3])
+	return pdf
+}
+
+// contentWidth is the width between the left and right margins, in mm.
+func (t Theme) contentWidth(pdf *gofpdf.Fpdf) float64 {
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	return pageWidth - left - right
+}
+
+// chartFont is the font charts and the locator map are labelled in, or nil
+// for the go-chart default.
+func (t Theme) chartFont() *truetype.Font {
+	return t.font
+}
+
+// htmlTheme is the part of the theme the HTML report template uses.
+type htmlTheme struct {
+	HeaderColor template.CSS
+	FontFace    template.CSS
+	FontFamily  template.CSS
+	Page        template.CSS
+	FooterText  string
+}
+
+// html turns the theme into CSS for the HTML report. An embedded font is
+// inlined as a data URI so the file stays self contained.
+func (t Theme) html() htmlTheme {
+	page := htmlTheme{
+		HeaderColor: "#c77d1a",
+		FontFamily:  template.CSS(t.Font + ", Arial, sans-serif"),
+		FooterText:  t.FooterText,
+	}
+	if t.HeaderColor != "" {
+		page.HeaderColor = template.CSS(t.HeaderColor)
+	}
+	if t.ttf != nil {
+		page.FontFace = template.CSS("@font-face { font-family: " + themeFontFamily + "; src: url(data:font/ttf;base64," + base64.StdEncoding.EncodeToString(t.ttf) + "); }")
+		page.FontFamily = template.CSS(themeFontFamily + ", " + string(page.FontFamily))
+	}
+	orientation := "portrait"
+	if t.Orientation == "L" {
+		orientation = "landscape"
+	}
+	margins := make([]string, 4)
+	for i, side := range []int{1, 2, 3, 0} {
+		margins[i] = strconv.FormatFloat(t.Margins[side], 'f', -1, 64) + "mm"
+	}
+	page.Page = template.CSS("size: " + strings.ToLower(t.PageSize) + " " + orientation + "; margin: " + strings.Join(margins, " ") + ";")
+	return page
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wcharczuk/go-chart"
+)
+
+// acreTotal is the number of treatments and acres treated in one group.
+type acreTotal struct {
+	Name       string
+	Treatments int
+	Acres      float64
+}
+
+// acreTotals adds up acres by the group each treatment falls in, largest first.
+func acreTotals(groups []string, acres []float64) []acreTotal {
+	var totals []acreTotal
+	index := map[string]int{}
+	for i, group := range groups {
+		if strings.TrimSpace(group) == "" {
+			group = "Unknown"
+		}
+		j, ok := index[group]
+		if !ok {
+			j = len(totals)
+			index[group] = j
+			totals = append(totals, acreTotal{Name: group})
+		}
+		totals[j].Treatments++
+		totals[j].Acres += acres[i]
+	}
+	sort.SliceStable(totals, func(i, j int) bool { return totals[i].Acres > totals[j].Acres })
+	return totals
+}
+
+// acreTable lists the totals under a caption, with the area in units.
+func acreTable(caption string, heading string, totals []acreTotal, units areaUnit) ContentTable {
+	table := ContentTable{
+		Caption: caption,
+		Widths:  []float64{110, 40, 40},
+		Headers: []string{heading, "Treatments", units.Header},
+		Align:   []string{"L", "R", "R"},
+	}
+	for _, total := range totals {
+		table.Rows = append(table.Rows, []string{total.Name, strconv.Itoa(total.Treatments), formatNumber(units.fromAcres(total.Acres), units.Decimals)})
+	}
+	return table
+}
+
+// firstTreatmentYear is the earliest Year_Cal taken as real. Earlier years,
+// and years still to come, are typing mistakes.
+const firstTreatmentYear = 1950
+
+// treatmentYear reads the calendar year a treatment was done in. Year_Cal
+// is text and sometimes a range such as "2014-2015", so the first four
+// digits are used. ok is false when there is no year, or it is before
+// firstTreatmentYear or after this year.
+func treatmentYear(text string) (year int, ok bool) {
+	text = strings.TrimSpace(text)
+	if len(text) < 4 {
+		return 0, false
+	}
+	year, err := strconv.Atoi(text[:4])
+	return year, err == nil && year >= firstTreatmentYear && year <= time.Now().Year()
+}
+
+// analytics is the area treated by year, agency, project type and land owner,
+// in the report units.
+func (s *vegetationTreatmentsSection) analytics() ([]ContentTable, []ContentChart) {
+	if len(s.data.Features) == 0 {
+		return nil, nil
+	}
+	units := s.units
+	var agencies, types, owners []string
+	var acres []float64
+	byYear := map[int]float64{}
+	for _, element := range s.data.Features {
+		a := element.Attributes
+		agencies = append(agencies, a.Agency)
+		types = append(types, a.ProjectType)
+		owners = append(owners, a.LandOwner)
+		acres = append(acres, a.AcreUS)
+		if year, ok := treatmentYear(a.YearCal); ok {
+			byYear[year] += a.AcreUS
+		}
+	}
+	agencyTotals := acreTotals(agencies, acres)
+	typeTotals := acreTotals(types, acres)
+	ownerTotals := acreTotals(owners, acres)
+	treated := units.Header + " treated by "
+	tables := []ContentTable{
+		acreTable(treated+"agency", "Agency", agencyTotals, units),
+		acreTable(treated+"project type", "Project type", typeTotals, units),
+		acreTable(treated+"land owner", "Land owner", ownerTotals, units),
+	}
+
+	var charts []ContentChart
+	if len(byYear) > 0 {
+		var years []int
+		for year := range byYear {
+			years = append(years, year)
+		}
+		sort.Ints(years)
+		var values []chart.Value
+		for _, year := range years {
+			values = append(values, chart.Value{Label: strconv.Itoa(year), Value: units.fromAcres(byYear[year])})
+		}
+		if png, err := barChart(treated+"year", values); err == nil {
+			charts = append(charts, ContentChart{Name: "treatmentyears", PNG: png, Width: 170, Height: 85})
+		} else {
+			log.Println("Rendering treatment year chart: ", err)
+		}
+	}
+	if png, err := barChart(treated+"agency", totalValues(agencyTotals, units)); err == nil {
+		charts = append(charts, ContentChart{Name: "treatmentagencies", PNG: png, Width: 170, Height: 85})
+	} else {
+		log.Println("Rendering treatment agency chart: ", err)
+	}
+	if png, err := barChart(treated+"project type", totalValues(typeTotals, units)); err == nil {
+		charts = append(charts, ContentChart{Name: "treatmenttypes", PNG: png, Width: 170, Height: 85})
+	} else {
+		log.Println("Rendering treatment type chart: ", err)
+	}
+	if ownerTotals[0].Acres > 0 {
+		var values []chart.Value
+		for _, total := range ownerTotals {
+			if total.Acres > 0 {
+				values = append(values, chart.Value{Label: total.Name + " " + formatNumber(units.fromAcres(total.Acres), 0) + " " + units.Label, Value: total.Acres})
+			}
+		}
+		pie := chart.PieChart{
+			Title:  treated + "land owner",
+			Font:   reportTheme.chartFont(),
+			Width:  512,
+			Height: 512,
+			Values: values,
+		}
+		buffer := bytes.NewBuffer([]byte{})
+		if err := pie.Render(chart.PNG, buffer); err == nil {
+			charts = append(charts, ContentChart{Name: "treatmentowners", PNG: buffer.Bytes(), Width: 128, Height: 128})
+		} else {
+			log.Println("Rendering land owner chart: ", err)
+		}
+	}
+	return tables, charts
+}
+
+// totalValues turns acre totals into chart bars in units.
+func totalValues(totals []acreTotal, units areaUnit) []chart.Value {
+	values := make([]chart.Value, len(totals))
+	for i, total := range totals {
+		values[i] = chart.Value{Label: chartLabel(total.Name), Value: units.fromAcres(total.Acres)}
+	}
+	return values
+}
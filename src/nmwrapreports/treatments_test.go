@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTreatmentYear(t *testing.T) {
+	thisYear := time.Now().Year()
+	tests := []struct {
+		text string
+		year int
+		ok   bool
+	}{
+		{"2014", 2014, true},
+		{" 2014-2015", 2014, true},
+		{"1950", 1950, true},
+		{strconv.Itoa(thisYear), thisYear, true},
+		{"1901", 0, false},
+		{strconv.Itoa(thisYear + 1), 0, false},
+		{"9999", 0, false},
+		{"FY14", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		year, ok := treatmentYear(test.text)
+		if ok != test.ok || (ok && year != test.year) {
+			t.Errorf("treatmentYear(%q) = %d, %t, want %d, %t", test.text, year, ok, test.year, test.ok)
+		}
+	}
+}
+
+func TestAcreTableUnits(t *testing.T) {
+	totals := acreTotals([]string{"BLM", "", "BLM"}, []float64{100, 50, 150})
+	tests := []struct {
+		units  string
+		header string
+		blm    string
+	}{
+		{"acres", "Acres", "250.0"},
+		{"hectares", "Hectares", "101.2"},
+		{"sqmi", "Sq miles", "0.39"},
+	}
+	for _, test := range tests {
+		table := acreTable("Treated", "Agency", totals, areaUnits[test.units])
+		if table.Headers[2] != test.header || table.Rows[0][0] != "BLM" || table.Rows[0][2] != test.blm {
+			t.Errorf("%s: %v %v, want %s %s for BLM", test.units, table.Headers, table.Rows, test.header, test.blm)
+		}
+		if table.Rows[1][0] != "Unknown" {
+			t.Errorf("%s: blank agency shown as %q", test.units, table.Rows[1][0])
+		}
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gdal "github.com/hbarrett/gdal"
+)
+
+// webMercatorWKT is the spatial reference uploads are reprojected to, to match
+// the report layers.
+const webMercatorWKT = "PROJCS[\"WGS 84 / Pseudo-Mercator\",GEOGCS[\"WGS 84\",DATUM[\"WGS_1984\",SPHEROID[\"WGS 84\",6378137,298.257223563,AUTHORITY[\"EPSG\",\"7030\"]],AUTHORITY[\"EPSG\",\"6326\"]],PRIMEM[\"Greenwich\",0,AUTHORITY[\"EPSG\",\"8901\"]],UNIT[\"degree\",0.0174532925199433,AUTHORITY[\"EPSG\",\"9122\"]],AUTHORITY[\"EPSG\",\"4326\"]],PROJECTION[\"Mercator_1SP\"],PARAMETER[\"central_meridian\",0],PARAMETER[\"scale_factor\",1],PARAMETER[\"false_easting\",0],PARAMETER[\"false_northing\",0],UNIT[\"metre\",1,AUTHORITY[\"EPSG\",\"9001\"]],AXIS[\"X\",EAST],AXIS[\"Y\",NORTH],EXTENSION[\"PROJ4\",\"+proj=merc +a=6378137 +b=6378137 +lat_ts=0.0 +lon_0=0.0 +x_0=0.0 +y_0=0 +k=1.0 +units=m +nadgrids=@null +wktext  +no_defs\"],AUTHORITY[\"EPSG\",\"3857\"]]"
+
+// uploadFeaturesHeader is the response header that says which features of an
+// upload made up the area of interest.
+const uploadFeaturesHeader = "X-Upload-Features"
+
+// uploadGeom reads the area of interest uploaded to /reportupload or
+// /extractfromupload. Every upload format comes through here. The area is
+// either the wkt form field or the file field: a zipped shapefile, a
+// GeoPackage, a KML file or a KMZ. The file type is told from its name and,
+// failing that, from the file itself. The title form field, when set,
+// overrides any name in the file. used describes the features the area was
+// made from, for the uploadFeaturesHeader.
+func uploadGeom(r *http.Request) (geom Geom, used string, err error) {
+	geom, used, err = uploadedGeom(r)
+	if title := r.FormValue("title"); title != "" {
+		geom.Title = title
+	}
+	return geom, used, err
+}
+
+// errSelectionFormat is the answer to where or fid on an upload whose
+// features can't be picked out.
+var errSelectionFormat = errors.New("where and fid can only pick features of a zipped shapefile or a GeoPackage")
+
+func uploadedGeom(r *http.Request) (Geom, string, error) {
+	selection, err := featureSelectionFromForm(r)
+	if err != nil {
+		return Geom{}, "", err
+	}
+	if text := strings.TrimSpace(r.FormValue("wkt")); text != "" {
+		if selection.isSet() {
+			return Geom{}, "", errSelectionFormat
+		}
+		geom, err := wktGeom(text, r.FormValue("wkid"))
+		return geom, "the WKT geometry", err
+	}
+	data, filename, err := uploadFile(r)
+	if err != nil {
+		return Geom{}, "", err
+	}
+	driverName, path, err := uploadDataset(data, filename)
+	if err != nil {
+		return Geom{}, "", err
+	}
+	defer removeUpload(driverName, path)
+	if driverName != "" {
+		return datasetGeom(driverName, path, r.FormValue("layer"), selection)
+	}
+	var read func(data []byte) (Geom, error)
+	switch ext := strings.ToLower(filepath.Ext(filename)); {
+	case ext == ".kml":
+		read = kmlGeom
+	case ext == ".kmz":
+		read = kmzGeom
+	case bytes.HasPrefix(data, []byte("PK")) && zipHas(data, ".kml"):
+		read = kmzGeom
+	case bytes.Contains(data, []byte("<kml")):
+		read = kmlGeom
+	default:
+		return Geom{}, "", errors.New("The upload must be a zipped shapefile, a GeoPackage, a KML or a KMZ file, or WKT")
+	}
+	if selection.isSet() {
+		return Geom{}, "", errSelectionFormat
+	}
+	geom, err := read(data)
+	return geom, "all KML polygons", err
+}
+
+// uploadFile reads the file form field.
+func uploadFile(r *http.Request) (data []byte, filename string, err error) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	data, err = ioutil.ReadAll(file)
+	return data, header.Filename, err
+}
+
+// uploadDataset saves an uploaded shapefile or GeoPackage to /tmp for OGR to
+// read, and gives the driver to read it with. driverName is empty when the
+// upload is neither.
+func uploadDataset(data []byte, filename string) (driverName string, path string, err error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".kml", ".kmz":
+		return "", "", nil
+	case ".gpkg":
+		path, err = saveGeoPackage(data)
+		return "GPKG", path, err
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("PK")) && zipHas(data, ".shp"):
+		path, err = saveShapefile(data)
+		return "ESRI Shapefile", path, err
+	case bytes.HasPrefix(data, []byte("SQLite format 3")):
+		path, err = saveGeoPackage(data)
+		return "GPKG", path, err
+	}
+	return "", "", nil
+}
+
+// removeUpload deletes what uploadDataset saved, once it has been read. A
+// shapefile is unpacked into a folder of its own and a GeoPackage is one file.
+func removeUpload(driverName string, path string) {
+	switch driverName {
+	case "ESRI Shapefile":
+		os.RemoveAll(filepath.Dir(path))
+	case "GPKG":
+		os.Remove(path)
+	}
+}
+
+// featureSelection picks the features of a shapefile or GeoPackage upload
+// that make up the area of interest. With neither Where nor FIDs set every
+// feature is used.
+type featureSelection struct {
+	Where string  // OGR SQL attribute filter, such as STATUS = 'Planned'
+	FIDs  []int64 // feature ids
+}
+
+// featureSelectionFromForm reads the where and fid form fields. fid is a
+// comma separated list of feature ids.
+func featureSelectionFromForm(r *http.Request) (featureSelection, error) {
+	selection := featureSelection{Where: strings.TrimSpace(r.FormValue("where"))}
+	for _, text := range strings.Split(r.FormValue("fid"), ",") {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		fid, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return selection, errors.New("fid must be a list of feature ids, not " + r.FormValue("fid"))
+		}
+		selection.FIDs = append(selection.FIDs, fid)
+	}
+	if selection.Where != "" && len(selection.FIDs) > 0 {
+		return selection, errors.New("Give either where or fid, not both")
+	}
+	return selection, nil
+}
+
+// isSet says whether any features were picked.
+func (s featureSelection) isSet() bool {
+	return s.Where != "" || len(s.FIDs) > 0
+}
+
+// describe says which features were used, given how many there were and how
+// many of those were skipped for having no geometry.
+func (s featureSelection) describe(count int, skipped int) string {
+	features := strconv.Itoa(count) + " features"
+	if count == 1 {
+		features = "1 feature"
+	}
+	switch {
+	case s.Where != "":
+		features += " where " + s.Where
+	case len(s.FIDs) > 0:
+		fids := make([]string, len(s.FIDs))
+		for i, fid := range s.FIDs {
+			fids[i] = strconv.FormatInt(fid, 10)
+		}
+		features += " with FID " + strings.Join(fids, ", ")
+	default:
+		features = "all " + features
+	}
+	features += ", dissolved"
+	if skipped > 0 {
+		features += " (" + strconv.Itoa(skipped) + " without geometry skipped)"
+	}
+	return features
+}
+
+// includes says whether the feature with id fid is selected. The where
+// filter is applied by OGR, so only FIDs are checked here.
+func (s featureSelection) includes(fid int64) bool {
+	if len(s.FIDs) == 0 {
+		return true
+	}
+	for _, selected := range s.FIDs {
+		if selected == fid {
+			return true
+		}
+	}
+	return false
+}
+
+// zipHas says whether a zip archive holds a file with the extension ext.
+func zipHas(data []byte, ext string) bool {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false
+	}
+	for _, f := range archive.File {
+		if strings.ToLower(filepath.Ext(f.Name)) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// saveShapefile unpacks a zipped shapefile into a folder of its own in /tmp
+// and gives the path of the .shp file. The zip itself isn't kept.
+func saveShapefile(data []byte) (string, error) {
+	AllowdShapeExtensions := []string{"cpg", "dbf", "prj", "sbn", "sbx", "shp", "shx"}
+	RandomFileName := RandString(10)
+	ZipFile := "/tmp/" + RandomFileName + ".zip"
+	if err := ioutil.WriteFile(ZipFile, data, 0644); err != nil {
+		return "", errors.New("Unable to create the file for writing. Check your write access privilege")
+	}
+	defer os.Remove(ZipFile)
+	reader, err := zip.OpenReader(ZipFile)
+	if err != nil {
+		return "", err
+	}
+	ShapeName := ""
+	defer reader.Close()
+	dest := "/tmp/" + RandomFileName
+	os.MkdirAll(dest, 755)
+	for _, f := range reader.File {
+		//Only the base name is used, so an entry like ../../etc/x.shp can't write outside dest.
+		name := filepath.Base(f.Name)
+		extension := strings.Split(name, ".")
+		path := dest + "/" + name
+		if len(extension) > 1 && stringInSlice(extension[1], AllowdShapeExtensions) {
+			if extension[1] == "shp" {
+				ShapeName = name
+			}
+
+			rc, err := f.Open()
+			logErr(err)
+			defer rc.Close()
+			f, err := os.OpenFile(
+				path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+			logErr(err)
+			defer f.Close()
+
+			_, err = io.Copy(f, rc)
+			logErr(err)
+		}
+	}
+	if ShapeName == "" {
+		os.RemoveAll(dest)
+		return "", errors.New("The zip file has no shapefile in it")
+	}
+	Shapefile := "/tmp/" + RandomFileName + "/" + ShapeName
+	return Shapefile, nil
+}
+
+// saveGeoPackage saves a GeoPackage to /tmp and gives its path.
+func saveGeoPackage(data []byte) (string, error) {
+	path := "/tmp/" + RandString(10) + ".gpkg"
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", errors.New("Unable to create the file for writing. Check your write access privilege")
+	}
+	return path, nil
+}
+
+// datasetGeom is the shared reader behind the shapefile and GeoPackage
+// uploads. It opens path with the OGR driver, picks the layer called
+// layerName or, when that is empty, the first polygon layer, and dissolves
+// the selected features into one area of interest.
+func datasetGeom(driverName string, path string, layerName string, selection featureSelection) (Geom, string, error) {
+	driver := gdal.OGRDriverByName(driverName)
+	datasource, ok := driver.Open(path, 0)
+	if !ok {
+		return Geom{}, "", errors.New("Could not open the " + driverName + " upload")
+	}
+	defer datasource.Destroy()
+	layer, err := polygonLayer(datasource, layerName)
+	if err != nil {
+		return Geom{}, "", err
+	}
+	var union gdal.Geometry
+	count, skipped := 0, 0
+	err = eachFeature(layer, selection, func(feature *gdal.Feature) error {
+		if feature.Geometry().IsEmpty() {
+			skipped++
+			return nil
+		}
+		if count == 0 {
+			union = feature.Geometry().Clone()
+		} else {
+			dissolved := union.Union(feature.Geometry())
+			union.Destroy()
+			union = dissolved
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return Geom{}, "", err
+	}
+	if count == 0 {
+		return Geom{}, "", errors.New("No features of layer " + layer.Name() + " with geometry were selected")
+	}
+	defer union.Destroy()
+	geom, err := ogrGeom(union)
+	return geom, selection.describe(count+skipped, skipped), err
+}
+
+// uploadFeature is one feature of a shapefile or GeoPackage upload, read as an
+// area of interest of its own for a batch of reports. Err is set when the
+// feature has no area that can be reported on.
+type uploadFeature struct {
+	FID  int64
+	Geom Geom
+	Err  error
+}
+
+// uploadFeatures reads the selected features of an uploaded shapefile or
+// GeoPackage one by one, for batchReportFromUpload. Each is titled from its
+// titleField attribute, or from its feature id when that is blank.
+func uploadFeatures(r *http.Request, titleField string) ([]uploadFeature, error) {
+	data, filename, err := uploadFile(r)
+	if err != nil {
+		return nil, err
+	}
+	selection, err := featureSelectionFromForm(r)
+	if err != nil {
+		return nil, err
+	}
+	driverName, path, err := uploadDataset(data, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer removeUpload(driverName, path)
+	if driverName == "" {
+		return nil, errors.New("A batch of reports needs a zipped shapefile or a GeoPackage")
+	}
+	driver := gdal.OGRDriverByName(driverName)
+	datasource, ok := driver.Open(path, 0)
+	if !ok {
+		return nil, errors.New("Could not open the " + driverName + " upload")
+	}
+	defer datasource.Destroy()
+	layer, err := polygonLayer(datasource, r.FormValue("layer"))
+	if err != nil {
+		return nil, err
+	}
+	if titleField != "" && layer.Definition().FieldIndex(titleField) < 0 {
+		return nil, errors.New("Layer " + layer.Name() + " has no field called " + titleField)
+	}
+	var features []uploadFeature
+	err = eachFeature(layer, selection, func(feature *gdal.Feature) error {
+		var geom Geom
+		var err error
+		if feature.Geometry().IsEmpty() {
+			err = errors.New("The feature has no geometry")
+		} else {
+			geom, err = ogrGeom(feature.Geometry())
+		}
+		if titleField != "" {
+			geom.Title = strings.TrimSpace(feature.FieldAsString(feature.FieldIndex(titleField)))
+		}
+		if geom.Title == "" {
+			geom.Title = "Feature " + strconv.FormatInt(feature.FID(), 10)
+		}
+		features = append(features, uploadFeature{FID: feature.FID(), Geom: geom, Err: err})
+		return nil
+	})
+	if err == nil && len(features) == 0 {
+		err = errors.New("No features of layer " + layer.Name() + " were selected")
+	}
+	return features, err
+}
+
+// eachFeature calls fn with every selected feature of layer, in order.
+func eachFeature(layer gdal.Layer, selection featureSelection, fn func(feature *gdal.Feature) error) error {
+	if err := layer.SetAttributeFilter(selection.Where); err != nil {
+		return errors.New("Could not apply the filter " + selection.Where)
+	}
+	layer.ResetReading()
+	found := 0
+	for feature := layer.NextFeature(); feature != nil; feature = layer.NextFeature() {
+		if selection.includes(feature.FID()) {
+			found++
+			if err := fn(feature); err != nil {
+				feature.Destroy()
+				return err
+			}
+		}
+		feature.Destroy()
+	}
+	if len(selection.FIDs) > 0 && found < len(selection.FIDs) {
+		return errors.New("Some of the feature ids " + fmt.Sprint(selection.FIDs) + " are not in layer " + layer.Name())
+	}
+	return nil
+}
+
+// polygonLayer finds the layer called name, or the first polygon layer when
+// name is empty.
+func polygonLayer(datasource gdal.DataSource, name string) (gdal.Layer, error) {
+	for i := 0; i < datasource.LayerCount(); i++ {
+		layer := datasource.LayerByIndex(i)
+		if name != "" && layer.Name() == name {
+			return layer, nil
+		}
+		if name == "" {
+			switch layer.Type() {
+			case gdal.GT_Polygon, gdal.GT_MultiPolygon, gdal.GT_Polygon25D, gdal.GT_MultiPolygon25D:
+				return layer, nil
+			}
+		}
+	}
+	if name != "" {
+		return gdal.Layer{}, errors.New("The upload has no layer called " + name)
+	}
+	return gdal.Layer{}, errors.New("The upload has no polygon layer")
+}
+
+// wktGeom reads a WKT polygon or multipolygon in the spatial reference wkid,
+// EPSG:4326 when wkid is empty.
+func wktGeom(text string, wkid string) (Geom, error) {
+	code := 4326
+	if wkid != "" {
+		var err error
+		if code, err = strconv.Atoi(wkid); err != nil {
+			return Geom{}, errors.New("wkid must be an EPSG code, not " + wkid)
+		}
+	}
+	spatialRef := gdal.CreateSpatialReference("")
+	if err := spatialRef.FromEPSG(code); err != nil {
+		return Geom{}, errors.New("Unknown wkid " + wkid)
+	}
+	geometry, err := gdal.CreateFromWKT(text, spatialRef)
+	if err != nil {
+		return Geom{}, errors.New("Could not read the WKT")
+	}
+	defer geometry.Destroy()
+	return ogrGeom(geometry)
+}
+
+// ogrGeom reprojects an OGR geometry to Web Mercator and converts it to Esri
+// rings through its GeoJSON, so multipolygons and holes come through whole.
+// A geometry that can't be reprojected, such as one from a shapefile without
+// its .prj, is an error rather than being read as Web Mercator already.
+func ogrGeom(geometry gdal.Geometry) (Geom, error) {
+	if err := geometry.TransformTo(gdal.CreateSpatialReference(webMercatorWKT)); err != nil {
+		log.Println("Reprojecting upload: ", err)
+		return Geom{}, errors.New("The upload could not be reprojected to Web Mercator. Check it has a spatial reference, such as the .prj file of a shapefile")
+	}
+	var input geoJSONInput
+	if err := json.Unmarshal([]byte(geometry.ToJSON()), &input); err != nil {
+		return Geom{}, err
+	}
+	var geom Geom
+	if err := input.addRings(&geom, true); err != nil {
+		return Geom{}, err
+	}
+	if len(geom.Rings) == 0 {
+		return Geom{}, errors.New("The upload has no polygons")
+	}
+	return geom, nil
+}
+
+// kmzGeom reads the KML in a KMZ: doc.kml, or the first KML file in it.
+func kmzGeom(data []byte) (Geom, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return Geom{}, err
+	}
+	var kml *zip.File
+	for _, f := range archive.File {
+		if strings.ToLower(filepath.Ext(f.Name)) != ".kml" {
+			continue
+		}
+		if kml == nil || strings.EqualFold(filepath.Base(f.Name), "doc.kml") {
+			kml = f
+		}
+	}
+	if kml == nil {
+		return Geom{}, errors.New("The KMZ has no KML file in it")
+	}
+	rc, err := kml.Open()
+	if err != nil {
+		return Geom{}, err
+	}
+	defer rc.Close()
+	doc, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return Geom{}, err
+	}
+	return kmlGeom(doc)
+}
+
+// kmlGeom reads every Polygon in a KML document, wherever it is: in a
+// Placemark, a MultiGeometry or a Folder. Each polygon's outerBoundaryIs and
+// innerBoundaryIs rings are converted from longitude/latitude to Web
+// Mercator Esri rings. The title is the name of the first Placemark, or of
+// the Document when no Placemark has one.
+func kmlGeom(data []byte) (Geom, error) {
+	var geom Geom
+	var documentName string
+	var polygon [][][]float64
+	var path []string
+	var text []byte
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Geom{}, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			text = text[:0]
+			if t.Name.Local == "Polygon" {
+				polygon = nil
+			}
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.EndElement:
+			parent := ""
+			if len(path) > 1 {
+				parent = path[len(path)-2]
+			}
+			switch {
+			case t.Name.Local == "Polygon":
+				geom.Rings = append(geom.Rings, esriPolygon(polygon, false)...)
+			case t.Name.Local == "name" && parent == "Placemark" && geom.Title == "":
+				geom.Title = strings.TrimSpace(string(text))
+			case t.Name.Local == "name" && parent == "Document" && documentName == "":
+				documentName = strings.TrimSpace(string(text))
+			case t.Name.Local == "coordinates" && kmlBoundary(path) == "outerBoundaryIs":
+				//The outer ring goes first so esriPolygon winds it clockwise.
+				polygon = append([][][]float64{kmlCoordinates(string(text))}, polygon...)
+			case t.Name.Local == "coordinates" && kmlBoundary(path) == "innerBoundaryIs":
+				polygon = append(polygon, kmlCoordinates(string(text)))
+			}
+			path = path[:len(path)-1]
+		}
+	}
+	if len(geom.Rings) == 0 {
+		return Geom{}, errors.New("The KML has no polygons")
+	}
+	if geom.Title == "" {
+		geom.Title = documentName
+	}
+	return geom, nil
+}
+
+// kmlBoundary is the boundary element a coordinates element is in, if any.
+func kmlBoundary(path []string) string {
+	for i := len(path) - 1; i >= 0 && path[i] != "Polygon"; i-- {
+		if path[i] == "outerBoundaryIs" || path[i] == "innerBoundaryIs" {
+			return path[i]
+		}
+	}
+	return ""
+}
+
+// kmlCoordinates parses a KML coordinates string of "lon,lat[,alt]" tuples
+// separated by white space.
+func kmlCoordinates(text string) [][]float64 {
+	var ring [][]float64
+	for _, tuple := range strings.Fields(text) {
+		parts := strings.Split(tuple, ",")
+		if len(parts) < 2 {
+			continue
+		}
+		lon, err1 := strconv.ParseFloat(parts[0], 64)
+		lat, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 == nil && err2 == nil {
+			ring = append(ring, []float64{lon, lat})
+		}
+	}
+	return ring
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// kmlSquare is a KML Polygon around a longitude/latitude box, with holes
+// around the other boxes given.
+func kmlSquare(outer [4]float64, holes ...[4]float64) string {
+	coordinates := func(b [4]float64) string {
+		west, south, east, north := b[0], b[1], b[2], b[3]
+		return ftoa(west) + "," + ftoa(south) + ",0 " + ftoa(east) + "," + ftoa(south) + ",0 " + ftoa(east) + "," + ftoa(north) + ",0 " + ftoa(west) + "," + ftoa(north) + ",0 " + ftoa(west) + "," + ftoa(south) + ",0"
+	}
+	kml := "<Polygon><outerBoundaryIs><LinearRing><coordinates>" + coordinates(outer) + "</coordinates></LinearRing></outerBoundaryIs>"
+	for _, hole := range holes {
+		kml += "<innerBoundaryIs><LinearRing><coordinates>" + coordinates(hole) + "</coordinates></LinearRing></innerBoundaryIs>"
+	}
+	return kml + "</Polygon>"
+}
+
+func ftoa(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func kmlDocument(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?><kml xmlns="http://www.opengis.net/kml/2.2">` + body + `</kml>`
+}
+
+func TestKMLGeom(t *testing.T) {
+	square := [4]float64{-106, 35, -105, 36}
+	hole := [4]float64{-105.75, 35.25, -105.25, 35.75}
+	other := [4]float64{-104, 32, -103, 33}
+	tests := []struct {
+		name  string
+		kml   string
+		rings int
+		area  float64
+		title string
+	}{
+		{"Placemark", kmlDocument(`<Placemark><name>Unit 1</name>` + kmlSquare(square) + `</Placemark>`), 1, boxArea(-106, 35, -105, 36), "Unit 1"},
+		{"inner boundary", kmlDocument(`<Placemark><name>Unit 1</name>` + kmlSquare(square, hole) + `</Placemark>`), 2, boxArea(-106, 35, -105, 36) - boxArea(-105.75, 35.25, -105.25, 35.75), "Unit 1"},
+		{"inner boundary before outer", kmlDocument(`<Placemark><Polygon><innerBoundaryIs><LinearRing><coordinates>-105.75,35.25 -105.25,35.25 -105.25,35.75 -105.75,35.75 -105.75,35.25</coordinates></LinearRing></innerBoundaryIs><outerBoundaryIs><LinearRing><coordinates>-106,35 -105,35 -105,36 -106,36 -106,35</coordinates></LinearRing></outerBoundaryIs></Polygon></Placemark>`), 2, boxArea(-106, 35, -105, 36) - boxArea(-105.75, 35.25, -105.25, 35.75), ""},
+		{"MultiGeometry", kmlDocument(`<Placemark><name>Units</name><MultiGeometry>` + kmlSquare(square) + kmlSquare(other) + `<Point><coordinates>-106,35</coordinates></Point></MultiGeometry></Placemark>`), 2, boxArea(-106, 35, -105, 36) + boxArea(-104, 32, -103, 33), "Units"},
+		{"every Placemark", kmlDocument(`<Document><Folder><Placemark><name>First</name>` + kmlSquare(square) + `</Placemark><Placemark><name>Second</name>` + kmlSquare(other) + `</Placemark></Folder></Document>`), 2, boxArea(-106, 35, -105, 36) + boxArea(-104, 32, -103, 33), "First"},
+		{"unnamed Placemark takes the next name", kmlDocument(`<Document><Placemark>` + kmlSquare(square) + `</Placemark><Placemark><name>Second</name>` + kmlSquare(other) + `</Placemark></Document>`), 2, boxArea(-106, 35, -105, 36) + boxArea(-104, 32, -103, 33), "Second"},
+		{"Document name", kmlDocument(`<Document><name>Treatment units</name><Placemark>` + kmlSquare(square) + `</Placemark></Document>`), 1, boxArea(-106, 35, -105, 36), "Treatment units"},
+		{"Placemark name before Document name", kmlDocument(`<Document><name>Treatment units</name><Placemark><name>Unit 1</name>` + kmlSquare(square) + `</Placemark></Document>`), 1, boxArea(-106, 35, -105, 36), "Unit 1"},
+		{"Style name is not a title", kmlDocument(`<Document><Style><name>red</name></Style><Placemark>` + kmlSquare(square) + `</Placemark></Document>`), 1, boxArea(-106, 35, -105, 36), ""},
+		{"no name", kmlDocument(`<Placemark>` + kmlSquare(square) + `</Placemark>`), 1, boxArea(-106, 35, -105, 36), ""},
+	}
+	for _, test := range tests {
+		geom, err := kmlGeom([]byte(test.kml))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(geom.Rings) != test.rings {
+			t.Errorf("%s: %d rings, want %d", test.name, len(geom.Rings), test.rings)
+		}
+		if got := geodesicArea(geom.Rings); !closeTo(got, test.area, 1e-6) {
+			t.Errorf("%s: area %f, want %f", test.name, got, test.area)
+		}
+		if geom.Title != test.title {
+			t.Errorf("%s: title %q, want %q", test.name, geom.Title, test.title)
+		}
+	}
+}
+
+func TestKMLGeomErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		kml  string
+		want string
+	}{
+		{"only a point", kmlDocument(`<Placemark><Point><coordinates>-106,35</coordinates></Point></Placemark>`), "no polygons"},
+		{"broken XML", `<kml><Placemark>`, "XML syntax error"},
+	}
+	for _, test := range tests {
+		_, err := kmlGeom([]byte(test.kml))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %v, want one containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+// zipOf is a ZIP holding files by name.
+func zipOf(t *testing.T, files map[string]string) []byte {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for name, content := range files {
+		f, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestKMZGeom(t *testing.T) {
+	data := zipOf(t, map[string]string{"doc.kml": kmlDocument(`<Placemark><name>Zipped</name>` + kmlSquare([4]float64{-106, 35, -105, 36}) + `</Placemark>`)})
+	geom, err := kmzGeom(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if geom.Title != "Zipped" || len(geom.Rings) != 1 {
+		t.Errorf("kmzGeom = %q with %d rings, want Zipped with 1", geom.Title, len(geom.Rings))
+	}
+}
+
+func TestSaveShapefileStaysInItsDirectory(t *testing.T) {
+	data := zipOf(t, map[string]string{"../../zipslip.shp": "shp", "../zipslip.dbf": "dbf"})
+	path, err := saveShapefile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { removeUpload("ESRI Shapefile", path) })
+	if filepath.Base(path) != "zipslip.shp" || filepath.Dir(filepath.Dir(path)) != "/tmp" {
+		t.Errorf("saveShapefile wrote %s", path)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), "zipslip.dbf")); err != nil {
+		t.Errorf("dbf not next to the shp: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(path) + ".zip"); !os.IsNotExist(err) {
+		t.Errorf("the uploaded zip was kept: %v", err)
+	}
+}
+
+func TestRemoveUploadedShapefile(t *testing.T) {
+	path, err := saveShapefile(zipOf(t, map[string]string{"unit.shp": "shp", "unit.dbf": "dbf"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeUpload("ESRI Shapefile", path)
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		os.RemoveAll(filepath.Dir(path))
+		t.Errorf("the unpacked shapefile was kept: %v", err)
+	}
+}
+
+func TestFeatureSelectionDescribe(t *testing.T) {
+	tests := []struct {
+		selection featureSelection
+		count     int
+		skipped   int
+		want      string
+	}{
+		{featureSelection{}, 5, 0, "all 5 features, dissolved"},
+		{featureSelection{}, 1, 0, "all 1 feature, dissolved"},
+		{featureSelection{Where: "STATUS = 'Planned'"}, 3, 1, "3 features where STATUS = 'Planned', dissolved (1 without geometry skipped)"},
+		{featureSelection{FIDs: []int64{1, 4}}, 2, 0, "2 features with FID 1, 4, dissolved"},
+	}
+	for _, test := range tests {
+		if got := test.selection.describe(test.count, test.skipped); got != test.want {
+			t.Errorf("describe(%d, %d) = %q, want %q", test.count, test.skipped, got, test.want)
+		}
+	}
+}
+
+func TestUploadedGeomRejectsSelectionOfWKT(t *testing.T) {
+	form := url.Values{"wkt": {"POLYGON ((-106 35, -105 35, -105 36, -106 36, -106 35))"}, "where": {"STATUS = 'Planned'"}}
+	r := httptest.NewRequest(http.MethodPost, "/reportupload", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, _, err := uploadedGeom(r); err != errSelectionFormat {
+		t.Errorf("uploadedGeom error %v, want %v", err, errSelectionFormat)
+	}
+}
+
+func TestRemoveUploadedGeoPackage(t *testing.T) {
+	driverName, path, err := uploadDataset([]byte("SQLite format 3\x00"), "units.gpkg")
+	if err != nil || driverName != "GPKG" {
+		t.Fatalf("uploadDataset = %q, %v", driverName, err)
+	}
+	removeUpload(driverName, path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		os.Remove(path)
+		t.Errorf("the GeoPackage was kept: %v", err)
+	}
+}
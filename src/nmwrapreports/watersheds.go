@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/wcharczuk/go-chart"
+	"github.com/wcharczuk/go-chart/drawing"
+)
+
+// Colours of the watershed risk tiers, matching the executive summary gauge.
+var (
+	tierLow    = drawing.Color{R: 76, G: 175, B: 80, A: 255}
+	tierMedium = drawing.Color{R: 255, G: 193, B: 7, A: 255}
+	tierHigh   = drawing.Color{R: 211, G: 47, B: 47, A: 255}
+)
+
+// attributeNumber reads a number ArcGIS sent as text, such as "12,345.6".
+// Anything that isn't a number counts as 0.
+func attributeNumber(text string) float64 {
+	value, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(text), ",", "", -1), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// profiles is a key metrics table for each intersecting watershed.
+func (s *watershedsHUC8Section) profiles() []ContentTable {
+	var tables []ContentTable
+	for i, element := range s.data.Features {
+		a := element.Attributes
+		integer := func(v int) string { return formatNumber(float64(v), 0) }
+		acres := func(text string) string { return formatNumber(attributeNumber(text), 0) }
+		table := ContentTable{
+			Caption: a.NAME + " (HUC " + a.HUC8 + ")",
+			Widths:  []float64{95, 95},
+			Headers: []string{"Metric", "Value"},
+			Align:   []string{"L", "R"},
+			Rows: [][]string{
+				{"States", a.STATES},
+				{"Area (acres)", integer(a.AREAACRES)},
+				{"Population", integer(a.PopulationWithinHUC8)},
+				{"Structures in the watershed", integer(a.TotalStructuresHUC)},
+				{"Structures in the WUI", integer(a.TotalStructuresInWUI)},
+				{"Intermix WUI structures", integer(a.F1IntermixWUIStructures)},
+				{"Interface WUI structures", integer(a.F2InterfaceWUIStructures)},
+				{"WUI acres", acres(a.TotalAcresWUI)},
+				{"Intermix / interface share of WUI", strconv.Itoa(a.IntermixPercent) + "% / " + strconv.Itoa(a.InterfacePercent) + "%"},
+				{"Wildfires 2006-2016", integer(a.WildfireCount2006_2016)},
+				{"Acres burned 2006-2016", acres(a.AcresBurned2006_2016)},
+				{"Treated acres", acres(a.TreatedAcres)},
+				{"Fire rank", strconv.Itoa(a.FireRank)},
+				{"Flood rank", a.FloodRankT},
+				{"Overall rank", a.RankText},
+				{"TNC ranking", strconv.Itoa(a.TNCRanking)},
+				{"TNC priority 4 / 5", strconv.Itoa(a.TNCPriority4) + " / " + strconv.Itoa(a.TNCPriority5)},
+				{"Low / medium / high risk", integer(a.LowRisk) + " / " + integer(a.MediumRisk) + " / " + integer(a.HighRisk)},
+			},
+		}
+		if i < len(s.overlaps) {
+			//Counts for the part of the watershed inside the area assume they are spread evenly over it.
+			inside, _ := overlapCells(s.overlaps, s.units, i)
+			table.Rows = append(table.Rows,
+				[]string{"Area inside the area of interest", inside},
+				[]string{"Share of the watershed inside the area", strconv.FormatFloat(100*s.overlaps[i].FeatureShare, 'f', 1, 64) + "%"},
+				[]string{"Population inside the area (pro-rated)", prorated(a.PopulationWithinHUC8, s.overlaps, i)},
+				[]string{"WUI structures inside the area (pro-rated)", prorated(a.TotalStructuresInWUI, s.overlaps, i)},
+			)
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// charts compares the intersecting watersheds: WUI structures, acres burned
+// and the split of each watershed into risk tiers.
+func (s *watershedsHUC8Section) charts() []ContentChart {
+	if len(s.data.Features) == 0 {
+		return nil
+	}
+	var structures, burned []chart.Value
+	var tiers []chart.StackedBar
+	for _, element := range s.data.Features {
+		a := element.Attributes
+		label := chartLabel(a.NAME)
+		structures = append(structures, chart.Value{Label: label, Value: float64(a.TotalStructuresInWUI)})
+		burned = append(burned, chart.Value{Label: label, Value: attributeNumber(a.AcresBurned2006_2016)})
+		tiers = append(tiers, chart.StackedBar{Name: label, Values: []chart.Value{
+			{Label: "Low", Value: float64(a.LowRisk), Style: chart.Style{FillColor: tierLow, StrokeColor: tierLow}},
+			{Label: "Medium", Value: float64(a.MediumRisk), Style: chart.Style{FillColor: tierMedium, StrokeColor: tierMedium}},
+			{Label: "High", Value: float64(a.HighRisk), Style: chart.Style{FillColor: tierHigh, StrokeColor: tierHigh}},
+		}})
+	}
+
+	var charts []ContentChart
+	if png, err := barChart("Structures in the wildland-urban interface", structures); err == nil {
+		charts = append(charts, ContentChart{Name: "huc8wui", PNG: png, Width: 170, Height: 85})
+	} else {
+		log.Println("Rendering WUI chart: ", err)
+	}
+	if png, err := barChart("Acres burned 2006-2016", burned); err == nil {
+		charts = append(charts, ContentChart{Name: "huc8burned", PNG: png, Width: 170, Height: 85})
+	} else {
+		log.Println("Rendering acres burned chart: ", err)
+	}
+	if len(tiers) > 0 && hasRiskTiers(tiers) {
+		stacked := chart.StackedBarChart{
+			Title:      "Risk tiers (low, medium and high from the bottom)",
+			TitleStyle: chart.Style{Show: true},
+			Font:       reportTheme.chartFont(),
+			Width:      1024,
+			Height:     512,
+			XAxis:      chart.Style{Show: true},
+			YAxis:      chart.Style{Show: true},
+			Bars:       tiers,
+		}
+		buffer := bytes.NewBuffer([]byte{})
+		if err := stacked.Render(chart.PNG, buffer); err == nil {
+			charts = append(charts, ContentChart{Name: "huc8tiers", PNG: buffer.Bytes(), Width: 170, Height: 85})
+		} else {
+			log.Println("Rendering risk tier chart: ", err)
+		}
+	}
+	return charts
+}
+
+// hasRiskTiers says whether any bar has a non-zero tier, as go-chart can't
+// draw a stacked chart of nothing but zeros.
+func hasRiskTiers(bars []chart.StackedBar) bool {
+	for _, bar := range bars {
+		for _, value := range bar.Values {
+			if value.Value > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}